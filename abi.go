@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gorilla/mux"
+)
+
+// *********************** contract abis ***************************************
+
+const (
+	ABI_DIR         = "abi"   // registered abis, one <endpoint>/<address>.json per contract
+	ABI_UPLOAD_SIZE = 4 << 20 // largest abi or build artifact accepted by an upload
+)
+
+// contractABI is the abi registered for one contract address
+type contractABI struct {
+	Address common.Address  `json:"address"`
+	Network string          `json:"network,omitempty"` // node url the contract lives on
+	Name    string          `json:"name"`
+	Raw     json.RawMessage `json:"abi"`
+	ABI     abi.ABI         `json:"-"`
+	Source  string          `json:"source,omitempty"` // build artifact path when matched from one, empty when registered
+	// deployed source map and source when the document was a Truffle artifact, for the debugger
+	SourceInfo *sourceInfo `json:"sourceMap,omitempty"`
+}
+
+// contractSummary is a registered contract as listed on /contracts
+type contractSummary struct {
+	Address   common.Address `json:"address"`
+	Name      string         `json:"name"`
+	Functions int            `json:"functions"`
+	Events    int            `json:"events"`
+	Source    string         `json:"source,omitempty"`
+}
+
+// abiRegistry holds every registered abi, keyed by node and contract address
+// since chains started from the same mnemonic share contract addresses
+type abiRegistry struct {
+	mu        sync.RWMutex
+	dir       string
+	contracts map[string]map[common.Address]*contractABI // node host -> contract -> abi
+}
+
+var contractABIs = &abiRegistry{dir: ABI_DIR, contracts: make(map[string]map[common.Address]*contractABI)}
+
+// for one decoded function or event argument
+type decodedArg struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// for calldata decoded with the abi of the called contract or the signature database
+type decodedCall struct {
+	Contract   string       `json:"contract"`
+	Method     string       `json:"method"`
+	Signature  string       `json:"signature"`
+	Args       []decodedArg `json:"args"`
+	Source     string       `json:"source"`               // DECODED_FROM_ABI or DECODED_FROM_SIGNATURES
+	Candidates []string     `json:"candidates,omitempty"` // every signature sharing the selector
+}
+
+// for one receipt log, decoded when the emitting contract has an abi or the topic is known
+type decodedLog struct {
+	Index      uint         `json:"logIndex"`
+	TxHash     string       `json:"txHash"`
+	Address    string       `json:"address"`
+	Contract   string       `json:"contract,omitempty"`
+	Event      string       `json:"event,omitempty"`
+	Signature  string       `json:"signature,omitempty"`
+	Args       []decodedArg `json:"args,omitempty"`
+	Source     string       `json:"source,omitempty"`
+	Candidates []string     `json:"candidates,omitempty"`
+	Topics     []string     `json:"topics"`
+	Data       string       `json:"data"`
+}
+
+/*
+parseABIDocument function: accepts either a bare abi array or a Truffle /
+Hardhat build artifact carrying one under "abi"; name is the artifact's
+contractName, empty for a bare abi
+*/
+func parseABIDocument(document []byte) (name string, raw json.RawMessage, parsed abi.ABI, err error) {
+	document = bytes.TrimSpace(document)
+	if len(document) > 0 && document[0] == '{' {
+		var artifact struct {
+			ContractName string          `json:"contractName"`
+			ABI          json.RawMessage `json:"abi"`
+		}
+		if err := json.Unmarshal(document, &artifact); err != nil {
+			return "", nil, abi.ABI{}, err
+		}
+		if len(artifact.ABI) == 0 {
+			return "", nil, abi.ABI{}, errors.New(`json object has no "abi" field`)
+		}
+		name, document = artifact.ContractName, artifact.ABI
+	}
+	parsed, err = abi.JSON(bytes.NewReader(document))
+	if err != nil {
+		return "", nil, abi.ABI{}, err
+	}
+	return name, json.RawMessage(document), parsed, nil
+}
+
+/*
+load function: reads every abi saved in the registry directory; a missing
+directory just means nothing was registered yet. Abis saved directly in it,
+by older versions, belong to the node at defaultHost
+*/
+func (reg *abiRegistry) load(defaultHost string) error {
+	legacy, err := filepath.Glob(filepath.Join(reg.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	perNode, err := filepath.Glob(filepath.Join(reg.dir, "*", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range append(legacy, perNode...) {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var stored contractABI
+		if err := json.Unmarshal(content, &stored); err != nil {
+			log.Printf("abi: skipping %s: %v", file, err)
+			continue
+		}
+		if stored.ABI, err = abi.JSON(bytes.NewReader(stored.Raw)); err != nil {
+			log.Printf("abi: skipping %s: %v", file, err)
+			continue
+		}
+		if stored.Network == "" {
+			if filepath.Dir(file) != filepath.Clean(reg.dir) {
+				log.Printf("abi: skipping %s: no network", file)
+				continue
+			}
+			stored.Network = defaultHost
+		}
+		reg.add(&stored)
+	}
+	return nil
+}
+
+/*
+register function: parses document (abi or build artifact) for address on the
+node at host, saves it to the registry directory and makes it available to the
+decoders of that node
+*/
+func (reg *abiRegistry) register(host string, address common.Address, name string, document []byte) (*contractABI, error) {
+	artifactName, raw, parsed, err := parseABIDocument(document)
+	if err != nil {
+		return nil, invalidInput("Not a valid contract ABI: " + err.Error())
+	}
+	if name == "" {
+		name = artifactName
+	}
+	entry := &contractABI{Address: address, Network: host, Name: name, Raw: raw, ABI: parsed, SourceInfo: parseSourceInfo(document)}
+
+	encoded, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(reg.dir, networkNameFor(host))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, &explorerError{Kind: ErrInternal, Message: "Couldn't able to save the ABI", Err: err}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, address.Hex()+".json"), encoded, 0644); err != nil {
+		return nil, &explorerError{Kind: ErrInternal, Message: "Couldn't able to save the ABI", Err: err}
+	}
+
+	reg.add(entry)
+	return entry, nil
+}
+
+// add makes entry available to the decoders of its node without saving it
+func (reg *abiRegistry) add(entry *contractABI) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.contracts[entry.Network] == nil {
+		reg.contracts[entry.Network] = make(map[common.Address]*contractABI)
+	}
+	reg.contracts[entry.Network][entry.Address] = entry
+}
+
+// forgetArtifacts drops every abi of the node at host that was matched from a build artifact
+func (reg *abiRegistry) forgetArtifacts(host string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for address, entry := range reg.contracts[host] {
+		if entry.Source != "" {
+			delete(reg.contracts[host], address)
+		}
+	}
+}
+
+func (reg *abiRegistry) lookup(host string, address common.Address) *contractABI {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.contracts[host][address]
+}
+
+func (reg *abiRegistry) list(host string) []contractSummary {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	list := make([]contractSummary, 0, len(reg.contracts[host]))
+	for _, entry := range reg.contracts[host] {
+		list = append(list, contractSummary{
+			Address:   entry.Address,
+			Name:      entry.Name,
+			Functions: len(entry.ABI.Methods),
+			Events:    len(entry.ABI.Events),
+			Source:    entry.Source,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].Address.Bytes(), list[j].Address.Bytes()) < 0
+	})
+	return list
+}
+
+// *********************** decoding ********************************************
+
+/*
+formatABIValue function: renders a value unpacked by the abi package the way
+it is written in solidity: checksummed addresses, hex bytes, decimal integers
+*/
+func formatABIValue(value interface{}) string {
+	switch v := value.(type) {
+	case common.Address:
+		return v.Hex()
+	case common.Hash:
+		return v.Hex()
+	case []byte:
+		return hexutil.Encode(v)
+	case *big.Int:
+		return v.String()
+	case string:
+		return v
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 { // bytesN
+			fixed := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(fixed), rv)
+			return hexutil.Encode(fixed)
+		}
+		fallthrough
+	case reflect.Slice:
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i] = formatABIValue(rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Struct: // tuple
+		fields := make([]string, rv.NumField())
+		for i := range fields {
+			fields[i] = rv.Type().Field(i).Name + ": " + formatABIValue(rv.Field(i).Interface())
+		}
+		return "(" + strings.Join(fields, ", ") + ")"
+	}
+	return fmt.Sprint(value)
+}
+
+func decodeArgs(arguments abi.Arguments, values []interface{}) []decodedArg {
+	args := make([]decodedArg, len(arguments))
+	for i, argument := range arguments {
+		args[i] = decodedArg{Name: argument.Name, Type: argument.Type.String(), Value: formatABIValue(values[i])}
+	}
+	return args
+}
+
+/*
+decodeCalldata function: decodes the function and arguments of a contract
+call, with the registered abi of the contract when there is one and the
+signature database otherwise; nil when there is nothing to decode
+*/
+func decodeCalldata(host string, to *common.Address, data []byte) *decodedCall {
+	if to == nil || len(data) < 4 {
+		return nil
+	}
+	entry := contractABIs.lookup(host, *to)
+	if entry != nil {
+		if method, err := entry.ABI.MethodById(data[:4]); err == nil {
+			if values, err := method.Inputs.Unpack(data[4:]); err == nil {
+				return &decodedCall{
+					Contract:  entry.Name,
+					Method:    method.RawName,
+					Signature: method.Sig,
+					Args:      decodeArgs(method.Inputs, values),
+					Source:    DECODED_FROM_ABI,
+				}
+			}
+		}
+	}
+	call := signatures.decodeCall(data)
+	if call != nil && entry != nil {
+		call.Contract = entry.Name
+	}
+	return call
+}
+
+/*
+isHashedTopic function: indexed dynamic values are stored as their keccak hash
+and cannot be decoded back
+*/
+func isHashedTopic(t abi.Type) bool {
+	switch t.T {
+	case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return true
+	}
+	return false
+}
+
+/*
+decodeEvent function: decodes log with the event of the matching abi, indexed
+arguments from the topics and the rest from the data
+*/
+func decodeEvent(event abi.Event, l *types.Log) ([]decodedArg, error) {
+	values, err := event.Inputs.NonIndexed().Unpack(l.Data)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]decodedArg, 0, len(event.Inputs))
+	topic, value := 1, 0
+	for _, input := range event.Inputs {
+		arg := decodedArg{Name: input.Name, Type: input.Type.String()}
+		if input.Indexed {
+			if topic >= len(l.Topics) {
+				return nil, errors.New("log has fewer topics than the event has indexed arguments")
+			}
+			if isHashedTopic(input.Type) {
+				arg.Value = l.Topics[topic].Hex() + " (hash)"
+			} else {
+				unindexed := input
+				unindexed.Indexed = false
+				decoded, err := abi.Arguments{unindexed}.Unpack(l.Topics[topic].Bytes())
+				if err != nil {
+					return nil, err
+				}
+				arg.Value = formatABIValue(decoded[0])
+			}
+			topic++
+		} else {
+			arg.Value = formatABIValue(values[value])
+			value++
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+/*
+DecodeReceiptLogs function: lists every log of a receipt, decoded with the abi
+of the emitting contract when one is registered for the node at host
+*/
+func DecodeReceiptLogs(host string, receipt *types.Receipt) []decodedLog {
+	logs := make([]decodedLog, 0, len(receipt.Logs))
+	for _, l := range receipt.Logs {
+		entry := decodedLog{
+			Index:   l.Index,
+			TxHash:  l.TxHash.Hex(),
+			Address: l.Address.Hex(),
+			Data:    hexutil.Encode(l.Data),
+		}
+		for _, topic := range l.Topics {
+			entry.Topics = append(entry.Topics, topic.Hex())
+		}
+		if contract := contractABIs.lookup(host, l.Address); contract != nil && len(l.Topics) > 0 {
+			entry.Contract = contract.Name
+			if event, err := contract.ABI.EventByID(l.Topics[0]); err == nil {
+				if args, err := decodeEvent(*event, l); err == nil {
+					entry.Event, entry.Signature, entry.Args = event.RawName, event.Sig, args
+					entry.Source = DECODED_FROM_ABI
+				}
+			}
+		}
+		if entry.Event == "" {
+			signatures.decodeLog(&entry, l)
+		}
+		logs = append(logs, entry)
+	}
+	return logs
+}
+
+// *********************** abi pages *******************************************
+
+// for the contracts page
+type contractsPage struct {
+	Contracts []contractSummary
+	Message   string
+}
+
+/*
+readABIUpload function: takes the abi of an upload form from the file field,
+or from the text field when no file was chosen
+*/
+func readABIUpload(r *http.Request) ([]byte, error) {
+	if err := r.ParseMultipartForm(ABI_UPLOAD_SIZE); err != nil && err != http.ErrNotMultipart {
+		return nil, invalidInput("Couldn't able to read the upload: " + err.Error())
+	}
+	if file, _, err := r.FormFile("abifile"); err == nil {
+		defer file.Close()
+		return ioutil.ReadAll(io.LimitReader(file, ABI_UPLOAD_SIZE))
+	}
+	if text := r.FormValue("abi"); strings.TrimSpace(text) != "" {
+		return []byte(text), nil
+	}
+	return nil, invalidInput("Paste the ABI JSON or choose an ABI / build artifact file")
+}
+
+/*
+contractsHandler function: serves /contracts; GET lists the registered abis,
+POST registers a new one from the upload form
+*/
+func contractsHandler(w http.ResponseWriter, r *http.Request) error {
+	data := contractsPage{}
+	if r.Method == http.MethodPost {
+		address := strings.TrimSpace(r.FormValue("address"))
+		if !common.IsHexAddress(address) {
+			return invalidInput("Contract address must be a 0x-prefixed 20 byte hex string")
+		}
+		document, err := readABIUpload(r)
+		if err != nil {
+			return err
+		}
+		entry, err := contractABIs.register(networkOf(r.Context()).URL, common.HexToAddress(address), strings.TrimSpace(r.FormValue("name")), document)
+		if err != nil {
+			return err
+		}
+		data.Message = "Registered the ABI of " + entry.Address.Hex()
+	}
+	data.Contracts = contractABIs.list(networkOf(r.Context()).URL)
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "contracts.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+/*
+apiContracts function: GET /api/v1/contracts, the contracts with a registered
+abi
+*/
+func apiContracts(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, http.StatusOK, contractABIs.list(networkOf(r.Context()).URL))
+}
+
+/*
+apiContractABI function: GET /api/v1/contracts/{address}/abi returns the
+registered abi, PUT registers the abi or build artifact sent as the body
+*/
+func apiContractABI(w http.ResponseWriter, r *http.Request) error {
+	address := mux.Vars(r)["address"]
+	if !common.IsHexAddress(address) {
+		return invalidInput("address must be a 0x-prefixed contract address")
+	}
+
+	if r.Method == http.MethodPut {
+		document, err := ioutil.ReadAll(io.LimitReader(r.Body, ABI_UPLOAD_SIZE))
+		if err != nil {
+			return invalidInput("Couldn't able to read the request body")
+		}
+		entry, err := contractABIs.register(networkOf(r.Context()).URL, common.HexToAddress(address), r.URL.Query().Get("name"), document)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, entry)
+	}
+
+	entry := contractABIs.lookup(networkOf(r.Context()).URL, common.HexToAddress(address))
+	if entry == nil {
+		return notFound("No ABI is registered for "+address, nil)
+	}
+	return writeJSON(w, http.StatusOK, entry)
+}
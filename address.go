@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// *********************** address history *************************************
+
+const (
+	ADDRESS_ACTIVITY_IN_PAGE = 25              // default rows per page of the address history
+	ADDRESS_SCAN_DEPTH       = 10000           // blocks scanned from the head when there is no index
+	ADDRESS_SCAN_BATCH       = 200             // blocks fetched per round of the scan
+	ADDRESS_SCAN_TIME        = 8 * time.Second // longest a scan runs, leaving the rest of the page its time
+	MAX_PAGE                 = 1000000         // highest ?page= taken, keeps page * page size far from overflowing
+)
+
+// for one row of an address history
+type addressActivity struct {
+	TxHash       common.Hash    `json:"txHash"`
+	BlockNumber  uint64         `json:"blockNumber"`
+	Timestamp    uint64         `json:"timestamp"`
+	Kind         string         `json:"kind"`      // TRANSACTION, CONTRACT CREATION, INTERNAL <type>
+	Direction    string         `json:"direction"` // IN, OUT, SELF
+	Counterparty common.Address `json:"counterparty"`
+	Value        *big.Int       `json:"value"`
+	ValueInEth   *big.Float     `json:"valueInEth"`
+	Status       string         `json:"status"`
+}
+
+// for an activity row together with the address it belongs to
+type activityRecord struct {
+	Address  common.Address
+	TxIndex  uint
+	Seq      uint32 // 0 for the transaction itself, 1+i for its i-th internal transfer
+	Activity addressActivity
+}
+
+// for the address page
+type addressPage struct {
+	accDetails
+	Tokens        []TokenHolding    `json:"tokens"`
+	Activity      []addressActivity `json:"activity"`
+	TotalActivity int               `json:"totalActivity"`
+	Page          int               `json:"page"`
+	NextPage      int               `json:"nextPage"`
+	PrevPage      int               `json:"prevPage"`
+	Indexed       bool              `json:"indexed"`        // false when built by scanning recent blocks
+	Scan          *addressScan      `json:"scan,omitempty"` // the blocks scanned, without the index
+}
+
+// addressScan tells which blocks a history built without the index covers
+type addressScan struct {
+	From    uint64 `json:"from"` // above To when no block could be scanned
+	To      uint64 `json:"to"`
+	Partial bool   `json:"partial"` // stopped on ADDRESS_SCAN_TIME before ADDRESS_SCAN_DEPTH blocks
+}
+
+func receiptStatus(receipt *types.Receipt) string {
+	if receipt != nil && receipt.Status == types.ReceiptStatusSuccessful {
+		return "SUCCESSFUL"
+	}
+	return "FAILED"
+}
+
+/*
+activityOf function: expands an indexed transaction into the history rows of
+every address it touched
+*/
+func activityOf(itx *indexedTx, timestamp uint64) []activityRecord {
+	var records []activityRecord
+	status := receiptStatus(itx.Receipt)
+	number := itx.Receipt.BlockNumber.Uint64()
+	txIndex := itx.Receipt.TransactionIndex
+
+	add := func(seq uint32, kind string, from, to common.Address, value *big.Int) {
+		row := addressActivity{
+			TxHash:      itx.Tx.Hash(),
+			BlockNumber: number,
+			Timestamp:   timestamp,
+			Kind:        kind,
+			Value:       value,
+			ValueInEth:  weiToEther(value),
+			Status:      status,
+		}
+		if from == to {
+			row.Direction, row.Counterparty = "SELF", to
+			records = append(records, activityRecord{Address: from, TxIndex: txIndex, Seq: seq, Activity: row})
+			return
+		}
+		out, in := row, row
+		out.Direction, out.Counterparty = "OUT", to
+		in.Direction, in.Counterparty = "IN", from
+		records = append(records,
+			activityRecord{Address: from, TxIndex: txIndex, Seq: seq, Activity: out},
+			activityRecord{Address: to, TxIndex: txIndex, Seq: seq, Activity: in})
+	}
+
+	if itx.Tx.To() == nil {
+		add(0, "CONTRACT CREATION", itx.From, itx.Receipt.ContractAddress, itx.Tx.Value())
+	} else {
+		add(0, "TRANSACTION", itx.From, *itx.Tx.To(), itx.Tx.Value())
+	}
+	for i, transfer := range itx.Internal {
+		add(uint32(i+1), "INTERNAL "+transfer.Type, transfer.From, transfer.To, transfer.Value)
+	}
+	return records
+}
+
+func activityKey(record activityRecord) []byte {
+	key := make([]byte, 0, common.AddressLength+16)
+	key = append(key, record.Address.Bytes()...)
+	key = append(key, encodeNumber(record.Activity.BlockNumber)...)
+	suffix := make([]byte, 8)
+	binary.BigEndian.PutUint32(suffix[:4], uint32(record.TxIndex))
+	binary.BigEndian.PutUint32(suffix[4:], record.Seq)
+	return append(key, suffix...)
+}
+
+/*
+addressActivity function: returns one page of the indexed history of address,
+newest first, with the total number of rows
+*/
+func (idx *blockIndex) addressActivity(address common.Address, offset, limit int) ([]addressActivity, int, error) {
+	var rows []addressActivity
+	total := 0
+	err := idx.db.View(func(btx *bolt.Tx) error {
+		var keys [][]byte
+		prefix := address.Bytes()
+		cursor := btx.Bucket(bucketAddressTxs).Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && hasPrefix(key, prefix); key, _ = cursor.Next() {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+		total = len(keys)
+		if offset < 0 || offset >= total {
+			return nil // past the last page
+		}
+		for i := total - 1 - offset; i >= 0 && len(rows) < limit; i-- {
+			var row addressActivity
+			if err := json.Unmarshal(btx.Bucket(bucketAddressTxs).Get(keys[i]), &row); err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+		return nil
+	})
+	return rows, total, err
+}
+
+func hasPrefix(key, prefix []byte) bool {
+	return len(key) >= len(prefix) && string(key[:len(prefix)]) == string(prefix)
+}
+
+/*
+scanAddressActivity function: builds the history of address from the node
+alone, walking back ADDRESS_SCAN_DEPTH blocks from the head in batches; stops
+early with a partial result after ADDRESS_SCAN_TIME. Internal transfers are
+not visible this way
+*/
+func scanAddressActivity(ctx context.Context, address common.Address) ([]addressActivity, addressScan, error) {
+	head, err := nodeOf(ctx).BlockNumber(ctx)
+	if err != nil {
+		return nil, addressScan{}, nodeError("Reason: `@BlockNumber` failed. Couldn't able to scan the chain", err)
+	}
+	scanCtx, cancel := context.WithTimeout(ctx, ADDRESS_SCAN_TIME)
+	defer cancel()
+
+	scan := addressScan{From: head + 1, To: head} // none scanned yet
+	lowest := uint64(0)
+	if head >= ADDRESS_SCAN_DEPTH {
+		lowest = head - ADDRESS_SCAN_DEPTH + 1
+	}
+	var rows []addressActivity
+	for next := int64(head); next >= int64(lowest); next -= ADDRESS_SCAN_BATCH {
+		var numbers []uint64
+		for n := next; n >= int64(lowest) && n > next-ADDRESS_SCAN_BATCH; n-- {
+			numbers = append(numbers, uint64(n))
+		}
+		chunk, err := scanBlocks(scanCtx, address, numbers)
+		if err != nil {
+			if scanCtx.Err() != nil && ctx.Err() == nil {
+				scan.Partial = true
+				break
+			}
+			return nil, scan, nodeError("Reason: `eth_getBlockByNumber` failed. Couldn't able to scan the blocks from "+strconv.FormatInt(next, 10), err)
+		}
+		rows = append(rows, chunk...)
+		scan.From = numbers[len(numbers)-1]
+	}
+	return rows, scan, nil
+}
+
+/*
+scanBlocks function: the history rows of address in the blocks numbered
+numbers, newest first, with the receipts of its transactions in one batch
+*/
+func scanBlocks(ctx context.Context, address common.Address, numbers []uint64) ([]addressActivity, error) {
+	blocks, err := readBlocks(ctx, networkOf(ctx).rpc, numbers)
+	if err != nil {
+		return nil, err
+	}
+	var matched types.Transactions
+	var senders []common.Address
+	var times []uint64
+	for _, block := range blocks {
+		txs := block.Transactions()
+		for i := len(txs) - 1; i >= 0; i-- {
+			tx := txs[i]
+			from, _ := types.LatestSignerForChainID(tx.ChainId()).Sender(tx)
+			if from != address && (tx.To() == nil || *tx.To() != address) && tx.To() != nil {
+				continue
+			}
+			matched = append(matched, tx)
+			senders = append(senders, from)
+			times = append(times, block.Time())
+		}
+	}
+	receipts, err := fetchReceipts(ctx, matched)
+	if err != nil {
+		return nil, err
+	}
+	var rows []addressActivity
+	for i, tx := range matched {
+		for _, record := range activityOf(&indexedTx{Tx: tx, From: senders[i], Receipt: receipts[i]}, times[i]) {
+			if record.Address == address {
+				rows = append(rows, record.Activity)
+			}
+		}
+	}
+	return rows, nil
+}
+
+/*
+loadAddressPage function: loads balance, nonce and one page of the history of
+the given address
+*/
+func loadAddressPage(ctx context.Context, qss string, page int) (addressPage, error) {
+	if !common.IsHexAddress(qss) {
+		return addressPage{}, invalidInput("Account address must be a 0x-prefixed 20 byte hex string")
+	}
+	address := common.HexToAddress(qss)
+
+	details, err := loadAccDetails(ctx, address.Hex())
+	if err != nil {
+		return addressPage{}, err
+	}
+
+	tokens, err := loadTokenPortfolio(ctx, address)
+	if err != nil {
+		return addressPage{}, err
+	}
+
+	data := addressPage{accDetails: details, Tokens: tokens, Page: page}
+	pageSize := config.Pages.AddressActivity
+	offset := page * pageSize
+	if idx := activeIndex(ctx); idx != nil {
+		data.Indexed = true
+		data.Activity, data.TotalActivity, err = idx.addressActivity(address, offset, pageSize)
+		if err != nil {
+			return addressPage{}, &explorerError{Kind: ErrInternal, Message: "Couldn't able to read the address history from the index", Err: err}
+		}
+	} else {
+		rows, scan, err := scanAddressActivity(ctx, address)
+		if err != nil {
+			return addressPage{}, err
+		}
+		data.Scan = &scan
+		data.TotalActivity = len(rows)
+		if offset >= 0 && offset < len(rows) {
+			end := offset + pageSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+			data.Activity = rows[offset:end]
+		}
+	}
+
+	if data.Activity == nil {
+		data.Activity = []addressActivity{}
+	}
+
+	data.PrevPage, data.NextPage = page-1, page+1
+	if data.PrevPage < 0 {
+		data.PrevPage = 0
+	}
+	if offset+pageSize >= data.TotalActivity {
+		data.NextPage = page
+	}
+	return data, nil
+}
+
+func pageParam(r *http.Request) (int, error) {
+	strPage := r.URL.Query().Get("page")
+	if strPage == "" {
+		return 0, nil
+	}
+	page, err := strconv.Atoi(strPage)
+	if err != nil || page < 0 || page > MAX_PAGE {
+		return 0, invalidInput("page must be a number from 0 to " + strconv.Itoa(MAX_PAGE))
+	}
+	return page, nil
+}
+
+/*
+addressInfoPage function: serves /address/{address}?page=N
+*/
+func addressInfoPage(w http.ResponseWriter, r *http.Request) error {
+	page, err := pageParam(r)
+	if err != nil {
+		return err
+	}
+	data, err := loadAddressPage(r.Context(), mux.Vars(r)["address"], page)
+	if err != nil {
+		return err
+	}
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "address.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
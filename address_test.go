@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestPageParam(t *testing.T) {
+	tests := []struct {
+		query string
+		page  int
+		ok    bool
+	}{
+		{"", 0, true},
+		{"page=0", 0, true},
+		{"page=3", 3, true},
+		{"page=" + strconv.Itoa(MAX_PAGE), MAX_PAGE, true},
+		{"page=" + strconv.Itoa(MAX_PAGE+1), 0, false},
+		{"page=" + strconv.Itoa(math.MaxInt64/25+1), 0, false}, // page * page size overflows
+		{"page=99999999999999999999", 0, false},
+		{"page=-1", 0, false},
+		{"page=two", 0, false},
+	}
+	for _, tt := range tests {
+		page, err := pageParam(httptest.NewRequest("GET", "/address/0x0?"+tt.query, nil))
+		if page != tt.page || (err == nil) != tt.ok {
+			t.Errorf("pageParam(%q) = %d, %v; want %d, ok %v", tt.query, page, err, tt.page, tt.ok)
+		}
+	}
+}
+
+func TestAddressActivityPages(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "index.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	address := common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	err = db.Update(func(btx *bolt.Tx) error {
+		bucket, err := btx.CreateBucket(bucketAddressTxs)
+		if err != nil {
+			return err
+		}
+		for number := uint64(1); number <= 5; number++ {
+			record := activityRecord{Address: address, Activity: addressActivity{BlockNumber: number}}
+			encoded, err := json.Marshal(record.Activity)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(activityKey(record), encoded); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := &blockIndex{db: db}
+
+	tests := []struct {
+		name   string
+		offset int
+		limit  int
+		blocks []uint64 // newest first
+	}{
+		{"first page", 0, 2, []uint64{5, 4}},
+		{"middle page", 2, 2, []uint64{3, 2}},
+		{"last page", 4, 2, []uint64{1}},
+		{"past the end", 5, 2, nil},
+		{"far past the end", MAX_PAGE * MAX_PAGE_SIZE, MAX_PAGE_SIZE, nil},
+		{"overflowed offset", math.MinInt64, 25, nil},
+		{"negative offset", -1, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, total, err := idx.addressActivity(address, tt.offset, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var blocks []uint64
+			for _, row := range rows {
+				blocks = append(blocks, row.BlockNumber)
+			}
+			if total != 5 || !reflect.DeepEqual(blocks, tt.blocks) {
+				t.Errorf("blocks %v of %d, want %v of 5", blocks, total, tt.blocks)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+)
+
+// *********************** api *************************************************
+
+// API_PREFIX is the root of the versioned JSON API
+const API_PREFIX = "/api/v1"
+
+var hashPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+
+// for the body of every failed api response
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// for the error envelope: {"error": {...}}
+type apiErrorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+/*
+writeJSON function: writes the given value as a json response with the status
+*/
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+/*
+apiSummary function: GET /api/v1/summary?page=N, mirrors the home page
+*/
+func apiSummary(w http.ResponseWriter, r *http.Request) error {
+	page, err := pageParam(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := loadSysInfo(r.Context(), int64(page))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
+
+/*
+apiBlock function: GET /api/v1/blocks/{block}, mirrors the block transactions
+page; block is either a number or a hash
+*/
+func apiBlock(w http.ResponseWriter, r *http.Request) error {
+	data, err := loadBlockTxPage(r.Context(), mux.Vars(r)["block"])
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
+
+/*
+apiBlockDetails function: GET /api/v1/blocks/{block}/details, mirrors the
+block details page; block is either a number or a hash
+*/
+func apiBlockDetails(w http.ResponseWriter, r *http.Request) error {
+	data, err := loadBlockInfo(r.Context(), mux.Vars(r)["block"])
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
+
+/*
+apiTransaction function: GET /api/v1/tx/{hash}, mirrors the transaction page
+*/
+func apiTransaction(w http.ResponseWriter, r *http.Request) error {
+	hash := mux.Vars(r)["hash"]
+	if !hashPattern.MatchString(hash) {
+		return invalidInput("hash must be a 0x-prefixed transaction hash")
+	}
+
+	data, err := loadTxDetails(r.Context(), hash)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
+
+/*
+apiAccount function: GET /api/v1/accounts/{address}, mirrors the account
+balance page
+*/
+func apiAccount(w http.ResponseWriter, r *http.Request) error {
+	address := mux.Vars(r)["address"]
+	if !common.IsHexAddress(address) {
+		return invalidInput("address must be a 0x-prefixed account address")
+	}
+
+	data, err := loadAccDetails(r.Context(), address)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
+
+/*
+apiAddressActivity function: GET /api/v1/accounts/{address}/transactions?page=N,
+mirrors the address page
+*/
+func apiAddressActivity(w http.ResponseWriter, r *http.Request) error {
+	page, err := pageParam(r)
+	if err != nil {
+		return err
+	}
+	data, err := loadAddressPage(r.Context(), mux.Vars(r)["address"], page)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
+
+/*
+apiAddressTokens function: GET /api/v1/accounts/{address}/tokens, the ERC-20
+portfolio shown on the address page
+*/
+func apiAddressTokens(w http.ResponseWriter, r *http.Request) error {
+	address := mux.Vars(r)["address"]
+	if !common.IsHexAddress(address) {
+		return invalidInput("address must be a 0x-prefixed account address")
+	}
+
+	data, err := loadTokenPortfolio(r.Context(), common.HexToAddress(address))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
+
+/*
+apiTokens function: GET /api/v1/tokens, mirrors the token registry page
+*/
+func apiTokens(w http.ResponseWriter, r *http.Request) error {
+	data, err := loadTokenList(r.Context())
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
+
+/*
+apiLabels function: GET /api/v1/labels, the contract name of every labelled
+address
+*/
+func apiLabels(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, http.StatusOK, addressLabels(r.Context()))
+}
+
+/*
+apiNotFound function: answers every unknown api path with the error envelope
+*/
+func apiNotFound(w http.ResponseWriter, r *http.Request) error {
+	return notFound("no such api endpoint: "+r.Method+" "+r.URL.Path, nil)
+}
+
+/*
+registerAPIRoutes function: mounts the /api/v1 tree on the given router
+*/
+func registerAPIRoutes(router *mux.Router) {
+	api := router.PathPrefix(API_PREFIX).Subrouter()
+
+	api.Handle("/summary", appHandler(apiSummary)).Methods(http.MethodGet)
+	api.Handle("/blocks/{block}/details", appHandler(apiBlockDetails)).Methods(http.MethodGet)
+	api.Handle("/blocks/{block}", appHandler(apiBlock)).Methods(http.MethodGet)
+	api.Handle("/tx/{hash}", appHandler(apiTransaction)).Methods(http.MethodGet)
+	api.Handle("/tx/{hash}/debug", appHandler(apiTxDebug)).Methods(http.MethodGet)
+	api.Handle("/accounts/{address}", appHandler(apiAccount)).Methods(http.MethodGet)
+	api.Handle("/accounts/{address}/transactions", appHandler(apiAddressActivity)).Methods(http.MethodGet)
+	api.Handle("/accounts/{address}/tokens", appHandler(apiAddressTokens)).Methods(http.MethodGet)
+	api.Handle("/tokens", appHandler(apiTokens)).Methods(http.MethodGet)
+	api.Handle("/pending", appHandler(apiPending)).Methods(http.MethodGet)
+	api.Handle("/search", appHandler(apiSearch)).Methods(http.MethodGet)
+	if config.Features.DevChain {
+		api.Handle("/admin", appHandler(apiDevChain)).Methods(http.MethodGet)
+		api.Handle("/admin/{action}", appHandler(apiDevChain)).Methods(http.MethodPost)
+	}
+	if config.Features.Send {
+		api.Handle("/send", appHandler(apiSend)).Methods(http.MethodPost)
+	}
+	if config.Features.Faucet {
+		api.Handle("/faucet", appHandler(apiFaucet)).Methods(http.MethodPost)
+	}
+	api.Handle("/contracts", appHandler(apiContracts)).Methods(http.MethodGet)
+	api.Handle("/contracts/{address}/abi", appHandler(apiContractABI)).Methods(http.MethodGet, http.MethodPut)
+	api.Handle("/labels", appHandler(apiLabels)).Methods(http.MethodGet)
+	api.Handle("/signatures/{hash}", appHandler(apiSignatures)).Methods(http.MethodGet)
+	api.Handle("/networks", appHandler(apiNetworks)).Methods(http.MethodGet, http.MethodPost)
+
+	// must stay last: catches everything the routes above did not match
+	api.PathPrefix("/").Handler(appHandler(apiNotFound))
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// *********************** build artifacts *************************************
+
+const ARTIFACT_SCAN_INTERVAL = 5 * time.Second // how often new deployments are matched
+
+// library link placeholders in unlinked bytecode: __$<34 hex chars>$__ or __Name____...
+var linkPlaceholder = regexp.MustCompile(`__.{36}__`)
+
+// contractArtifact is one Truffle or Hardhat build artifact with deployable code
+type contractArtifact struct {
+	Name             string
+	Path             string
+	Raw              json.RawMessage
+	ABI              abi.ABI
+	DeployedBytecode []byte
+	Networks         map[string]common.Address // network id -> address, Truffle only
+	SourceInfo       *sourceInfo               // Truffle only
+}
+
+// artifactMatcher labels the contracts of the chain that come from a loaded artifact
+type artifactMatcher struct {
+	mu        sync.RWMutex
+	artifacts []*contractArtifact
+	host      string                    // node the labels below belong to
+	scanned   uint64                    // blocks below this were already searched for deployments
+	labels    map[common.Address]string // contract address -> contract name
+}
+
+var artifacts = &artifactMatcher{labels: make(map[common.Address]string)}
+
+/*
+loadArtifacts function: reads every build artifact below dir; Truffle keeps
+them in build/contracts/*.json, Hardhat in artifacts/<source>/<Name>.json next
+to .dbg.json files that are skipped
+*/
+func loadArtifacts(dir string) ([]*contractArtifact, error) {
+	var loaded []*contractArtifact
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" || strings.HasSuffix(path, ".dbg.json") {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if artifact, ok := parseArtifact(path, content); ok {
+			loaded = append(loaded, artifact)
+		}
+		return nil
+	})
+	return loaded, err
+}
+
+/*
+parseArtifact function: ok is false for json files that are not build
+artifacts, e.g. Hardhat build-info or package files
+*/
+func parseArtifact(path string, content []byte) (*contractArtifact, bool) {
+	var document struct {
+		ContractName     string          `json:"contractName"`
+		ABI              json.RawMessage `json:"abi"`
+		DeployedBytecode string          `json:"deployedBytecode"`
+		Networks         map[string]struct {
+			Address string `json:"address"`
+		} `json:"networks"`
+	}
+	if err := json.Unmarshal(content, &document); err != nil || document.ContractName == "" || len(document.ABI) == 0 {
+		return nil, false
+	}
+	parsed, err := abi.JSON(bytes.NewReader(document.ABI))
+	if err != nil {
+		log.Printf("artifacts: skipping %s: %v", path, err)
+		return nil, false
+	}
+
+	artifact := &contractArtifact{
+		Name:       document.ContractName,
+		Path:       path,
+		Raw:        document.ABI,
+		ABI:        parsed,
+		Networks:   make(map[string]common.Address),
+		SourceInfo: parseSourceInfo(content),
+	}
+	// unlinked library addresses are compared as zeros, like immutables
+	code := linkPlaceholder.ReplaceAllString(document.DeployedBytecode, strings.Repeat("0", 40))
+	if decoded, err := hexutil.Decode(code); err == nil {
+		artifact.DeployedBytecode = stripMetadata(decoded)
+	}
+	for id, network := range document.Networks {
+		if common.IsHexAddress(network.Address) {
+			artifact.Networks[id] = common.HexToAddress(network.Address)
+		}
+	}
+	return artifact, true
+}
+
+/*
+stripMetadata function: drops the CBOR metadata solc appends to runtime code,
+which changes with every recompilation without changing the contract
+*/
+func stripMetadata(code []byte) []byte {
+	if len(code) < 2 {
+		return code
+	}
+	length := int(code[len(code)-2])<<8 | int(code[len(code)-1])
+	start := len(code) - 2 - length
+	if length == 0 || start < 0 || code[start]&0xe0 != 0xa0 { // CBOR map header
+		return code
+	}
+	return code[:start]
+}
+
+/*
+codeMatches function: compares deployed code with the code of an artifact;
+bytes the artifact leaves zero (immutables, library addresses) may differ
+*/
+func codeMatches(deployed, artifact []byte) bool {
+	deployed = stripMetadata(deployed)
+	if len(artifact) == 0 || len(deployed) != len(artifact) {
+		return false
+	}
+	for i := range deployed {
+		if deployed[i] != artifact[i] && artifact[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// *********************** labels **********************************************
+
+/*
+run function: matches the artifacts against the chain of net every
+ARTIFACT_SCAN_INTERVAL, following new deployments as they are mined
+*/
+func (m *artifactMatcher) run(net *network) {
+	ctx := withNetworkContext(context.Background(), net, "")
+	for {
+		if err := m.scan(ctx); err != nil {
+			log.Println("artifacts:", err)
+		}
+		time.Sleep(ARTIFACT_SCAN_INTERVAL)
+	}
+}
+
+/*
+scan function: labels the addresses recorded in the artifacts for the node's
+network id, then every contract created since the last scan whose code matches
+an artifact; starts over when the node changed or dropped blocks
+*/
+func (m *artifactMatcher) scan(ctx context.Context) error {
+	host := networkOf(ctx).URL
+	head, err := nodeOf(ctx).BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if m.host != host || head+1 < m.scanned {
+		m.host, m.scanned, m.labels = host, 0, make(map[common.Address]string)
+		contractABIs.forgetArtifacts(host)
+	}
+	from := m.scanned
+	m.mu.Unlock()
+
+	networkID, err := nodeOf(ctx).NetworkID(ctx)
+	if err != nil {
+		return err
+	}
+	for _, artifact := range m.artifacts {
+		if address, ok := artifact.Networks[networkID.String()]; ok {
+			if code, err := nodeOf(ctx).CodeAt(ctx, address, nil); err == nil && len(code) > 0 {
+				m.label(host, address, artifact)
+			}
+		}
+	}
+
+	for n := from; n <= head; n++ {
+		block, err := fetchBlockByNumber(ctx, new(big.Int).SetUint64(n))
+		if err != nil {
+			return err
+		}
+		var creations types.Transactions
+		for _, tx := range block.Transactions() {
+			if tx.To() == nil {
+				creations = append(creations, tx)
+			}
+		}
+		receipts, err := fetchReceipts(ctx, creations)
+		if err != nil {
+			return err
+		}
+		for _, receipt := range receipts {
+			code, err := nodeOf(ctx).CodeAt(ctx, receipt.ContractAddress, nil)
+			if err != nil {
+				return err
+			}
+			for _, artifact := range m.artifacts {
+				if codeMatches(code, artifact.DeployedBytecode) {
+					m.label(host, receipt.ContractAddress, artifact)
+					break
+				}
+			}
+		}
+		m.mu.Lock()
+		m.scanned = n + 1
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+/*
+label function: names address after artifact and lends its abi to the
+decoders of the node at host, unless an abi was registered for the address by
+hand
+*/
+func (m *artifactMatcher) label(host string, address common.Address, artifact *contractArtifact) {
+	m.mu.Lock()
+	m.labels[address] = artifact.Name
+	m.mu.Unlock()
+	if contractABIs.lookup(host, address) == nil {
+		contractABIs.add(&contractABI{Address: address, Network: host, Name: artifact.Name, Raw: artifact.Raw, ABI: artifact.ABI, Source: artifact.Path, SourceInfo: artifact.SourceInfo})
+	}
+}
+
+func (m *artifactMatcher) labelOf(ctx context.Context, address common.Address) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.host != networkOf(ctx).URL {
+		return ""
+	}
+	return m.labels[address]
+}
+
+/*
+addressLabel function: the contract name of an address, from a matched build
+artifact or a registered abi; empty when the address is unknown. Accepts the
+address as rendered by any page
+*/
+func addressLabel(ctx context.Context, value interface{}) string {
+	var address common.Address
+	switch v := value.(type) {
+	case common.Address:
+		address = v
+	case *common.Address:
+		if v == nil {
+			return ""
+		}
+		address = *v
+	case string:
+		fields := strings.Fields(v) // e.g. "0x... [CONTRACT CREATION]"
+		if len(fields) == 0 || !common.IsHexAddress(fields[0]) {
+			return ""
+		}
+		address = common.HexToAddress(fields[0])
+	default:
+		return ""
+	}
+
+	if name := artifacts.labelOf(ctx, address); name != "" {
+		return name
+	}
+	if entry := contractABIs.lookup(networkOf(ctx).URL, address); entry != nil {
+		return entry.Name
+	}
+	return ""
+}
+
+/*
+addressLabels function: every labelled address, for the api
+*/
+func addressLabels(ctx context.Context) map[string]string {
+	labels := make(map[string]string)
+	for _, contract := range contractABIs.list(networkOf(ctx).URL) {
+		if contract.Name != "" {
+			labels[contract.Address.Hex()] = contract.Name
+		}
+	}
+	artifacts.mu.RLock()
+	defer artifacts.mu.RUnlock()
+	if artifacts.host == networkOf(ctx).URL {
+		for address, name := range artifacts.labels {
+			labels[address.Hex()] = name
+		}
+	}
+	return labels
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// *********************** templates and static files **************************
+
+// the page templates and static files, built into the binary so it runs from
+// any directory
+var (
+	//go:embed template
+	embeddedTemplates embed.FS
+	//go:embed static
+	embeddedStatic embed.FS
+)
+
+// pageSet holds the page templates, parsed once, and their copies bound to
+// each network
+type pageSet struct {
+	mu     sync.Mutex
+	parsed *template.Template            // every page and the layout, bound to no network
+	bound  map[string]*template.Template // network|base -> the pages bound to it
+}
+
+var pages = &pageSet{bound: make(map[string]*template.Template)}
+
+/*
+templateFuncs function: the functions of every page template, bound to the
+network of the request
+*/
+func templateFuncs(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		// contract name of an address, empty when unknown
+		"label": func(value interface{}) string { return addressLabel(ctx, value) },
+		// path prefix of the network for the links of the page
+		"base":     func() string { return networkBase(ctx) },
+		"network":  func() string { return networkOf(ctx).Name },
+		"networks": networks.list,
+		"enabled":  config.enabled,
+	}
+}
+
+/*
+templateFiles function: the page templates, from the -templates directory when
+one is given and otherwise from the binary
+*/
+func templateFiles() (fs.FS, error) {
+	if config.Paths.Templates != "" {
+		return os.DirFS(config.Paths.Templates), nil
+	}
+	return fs.Sub(embeddedTemplates, "template")
+}
+
+/*
+staticFiles function: the static files, from the -static directory when one
+is given and otherwise from the binary
+*/
+func staticFiles() (http.FileSystem, error) {
+	if config.Paths.Static != "" {
+		return http.Dir(config.Paths.Static), nil
+	}
+	files, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		return nil, err
+	}
+	return http.FS(files), nil
+}
+
+/*
+parsePages function: parses every page template together with the layout
+partials they share
+*/
+func parsePages() (*template.Template, error) {
+	files, err := templateFiles()
+	if err != nil {
+		return nil, err
+	}
+	return template.New("").Funcs(templateFuncs(context.Background())).ParseFS(files, "*.html")
+}
+
+/*
+load function: parses the pages once for the server to share
+*/
+func (set *pageSet) load() error {
+	parsed, err := parsePages()
+	if err != nil {
+		return err
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.parsed = parsed
+	set.bound = make(map[string]*template.Template)
+	return nil
+}
+
+/*
+forNetwork function: the pages bound to the network and link prefix of ctx,
+copied from the parsed ones on first use; with -dev they are parsed from disk
+on every call so edited templates show on the next reload
+*/
+func (set *pageSet) forNetwork(ctx context.Context) (*template.Template, error) {
+	net, base := networkOf(ctx), networkBase(ctx)
+	bound := withNetworkContext(context.Background(), net, base)
+	if config.Dev {
+		parsed, err := parsePages()
+		if err != nil {
+			return nil, err
+		}
+		return parsed.Funcs(templateFuncs(bound)), nil
+	}
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	key := net.Name + "|" + base
+	if tmpl, ok := set.bound[key]; ok {
+		return tmpl, nil
+	}
+	tmpl, err := set.parsed.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tmpl.Funcs(templateFuncs(bound))
+	set.bound[key] = tmpl
+	return tmpl, nil
+}
+
+/*
+pageTemplate function: the page template name, bound to the network of the
+request
+*/
+func pageTemplate(ctx context.Context, name string) (*template.Template, error) {
+	set, err := pages.forNetwork(ctx)
+	if err != nil {
+		return nil, &explorerError{Kind: ErrInternal, Message: "Couldn't able to load the page templates", Err: err}
+	}
+	tmpl := set.Lookup(name)
+	if tmpl == nil {
+		return nil, &explorerError{Kind: ErrInternal, Message: "No page template " + name}
+	}
+	return tmpl, nil
+}
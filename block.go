@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// *********************** blocks **********************************************
+
+// headerExtension holds the header fields added after London, which
+// types.Header of our go-ethereum does not know but which are part of the hash
+type headerExtension struct {
+	WithdrawalsRoot       *common.Hash    `json:"withdrawalsRoot,omitempty"`       // Shanghai
+	BlobGasUsed           *hexutil.Uint64 `json:"blobGasUsed,omitempty"`           // Cancun
+	ExcessBlobGas         *hexutil.Uint64 `json:"excessBlobGas,omitempty"`         // Cancun
+	ParentBeaconBlockRoot *common.Hash    `json:"parentBeaconBlockRoot,omitempty"` // Cancun
+	RequestsHash          *common.Hash    `json:"requestsHash,omitempty"`          // Prague
+}
+
+// withdrawal is a validator withdrawal paid out by a post-Shanghai block
+type withdrawal struct {
+	Index     hexutil.Uint64 `json:"index"`
+	Validator hexutil.Uint64 `json:"validatorIndex"`
+	Address   common.Address `json:"address"`
+	Amount    hexutil.Uint64 `json:"amount"` // in gwei
+}
+
+// unsupportedTx is a transaction of a type our go-ethereum cannot decode, like
+// the blob transactions (type 3) of Cancun; the block lists what the node tells
+type unsupportedTx struct {
+	Hash common.Hash     `json:"hash"`
+	Type hexutil.Uint64  `json:"type"`
+	From common.Address  `json:"from"`
+	To   *common.Address `json:"to"`
+}
+
+// rpcBlock is a block as eth_getBlockByNumber and eth_getBlockByHash return
+// it with full transactions, less the header fields types.Header decodes
+type rpcBlock struct {
+	Hash         *common.Hash      `json:"hash"` // null for pending blocks
+	Size         hexutil.Uint64    `json:"size"`
+	Transactions []json.RawMessage `json:"transactions"` // decoded one by one, see decodeBlock
+	Uncles       []common.Hash     `json:"uncles"`
+	Withdrawals  []withdrawal      `json:"withdrawals"`
+	headerExtension
+}
+
+/*
+chainBlock is the block every page works with: the go-ethereum block plus the
+hash the node knows it by and the parts types.Block cannot hold. Ganache hashes
+some headers its own way and types.Header drops the post-London fields, so
+block.Block.Hash() may name no block the node has
+*/
+type chainBlock struct {
+	*types.Block
+	hash        common.Hash
+	size        uint64 // as reported, 0 when unknown
+	Extension   headerExtension
+	Withdrawals []withdrawal
+	UncleHashes []common.Hash
+	Unsupported []unsupportedTx // left out of Transactions()
+}
+
+// Hash is the hash the node reports for the block, which lookups go by
+func (b *chainBlock) Hash() common.Hash { return b.hash }
+
+// transactionCount counts the transactions of the block, those of unsupported types too
+func (b *chainBlock) transactionCount() int {
+	return len(b.Transactions()) + len(b.Unsupported)
+}
+
+// Size is the encoded size the node reports, computed when it reported none
+func (b *chainBlock) Size() common.StorageSize {
+	if b.size > 0 {
+		return common.StorageSize(b.size)
+	}
+	return b.Block.Size()
+}
+
+/*
+deriveBlockHash function: hashes header the way the protocol does, appending
+the post-London fields present in ext; types.Header.Hash only knows those up
+to the base fee
+*/
+func deriveBlockHash(header *types.Header, ext headerExtension) common.Hash {
+	fields := []interface{}{
+		header.ParentHash, header.UncleHash, header.Coinbase, header.Root,
+		header.TxHash, header.ReceiptHash, header.Bloom, header.Difficulty,
+		header.Number, header.GasLimit, header.GasUsed, header.Time,
+		header.Extra, header.MixDigest, header.Nonce,
+	}
+	if header.BaseFee == nil {
+		return rlpHash(fields)
+	}
+	fields = append(fields, header.BaseFee)
+	if ext.WithdrawalsRoot == nil {
+		return rlpHash(fields)
+	}
+	fields = append(fields, *ext.WithdrawalsRoot)
+	if ext.BlobGasUsed == nil || ext.ExcessBlobGas == nil || ext.ParentBeaconBlockRoot == nil {
+		return rlpHash(fields)
+	}
+	fields = append(fields, uint64(*ext.BlobGasUsed), uint64(*ext.ExcessBlobGas), *ext.ParentBeaconBlockRoot)
+	if ext.RequestsHash != nil {
+		fields = append(fields, *ext.RequestsHash)
+	}
+	return rlpHash(fields)
+}
+
+func rlpHash(value interface{}) common.Hash {
+	encoded, err := rlp.EncodeToBytes(value)
+	if err != nil {
+		return common.Hash{}
+	}
+	return crypto.Keccak256Hash(encoded)
+}
+
+/*
+decodeBlock function: builds the chainBlock of an eth_getBlockBy* result,
+ethereum.NotFound when the node returned null. Transactions of types our
+go-ethereum does not know go to Unsupported instead of failing the block
+*/
+func decodeBlock(raw json.RawMessage) (*chainBlock, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+	header := new(types.Header)
+	if err := json.Unmarshal(raw, header); err != nil {
+		return nil, err
+	}
+	var body rpcBlock
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, err
+	}
+	var txs []*types.Transaction
+	var unsupported []unsupportedTx
+	for _, raw := range body.Transactions {
+		tx := new(types.Transaction)
+		err := json.Unmarshal(raw, tx)
+		if errors.Is(err, types.ErrTxTypeNotSupported) {
+			var unknown unsupportedTx
+			if err := json.Unmarshal(raw, &unknown); err != nil {
+				return nil, err
+			}
+			unsupported = append(unsupported, unknown)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	block := &chainBlock{
+		Block:       types.NewBlockWithHeader(header).WithBody(txs, nil),
+		size:        uint64(body.Size),
+		Extension:   body.headerExtension,
+		Withdrawals: body.Withdrawals,
+		UncleHashes: body.Uncles,
+		Unsupported: unsupported,
+	}
+	block.hash = deriveBlockHash(header, body.headerExtension)
+	if body.Hash != nil {
+		block.hash = *body.Hash
+	}
+	return block, nil
+}
+
+/*
+readBlock function: reads a block with its transactions from the node, by
+number (nil for the latest) or by hash
+*/
+func readBlock(ctx context.Context, node *EthRPC, id interface{}) (*chainBlock, error) {
+	var raw json.RawMessage
+	var err error
+	switch id := id.(type) {
+	case common.Hash:
+		raw, err = node.CallContext(ctx, "eth_getBlockByHash", id, true)
+	case *big.Int:
+		number := "latest"
+		if id != nil {
+			number = hexutil.EncodeBig(id)
+		}
+		raw, err = node.CallContext(ctx, "eth_getBlockByNumber", number, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeBlock(raw)
+}
+
+/*
+readBlocks function: reads the blocks with the given numbers and their
+transactions from the node in batched round trips
+*/
+func readBlocks(ctx context.Context, node *EthRPC, numbers []uint64) ([]*chainBlock, error) {
+	raws := make([]json.RawMessage, len(numbers))
+	batch := make([]BatchElem, len(numbers))
+	for i, n := range numbers {
+		batch[i] = BatchElem{Method: "eth_getBlockByNumber", Params: []interface{}{hexutil.EncodeUint64(n), true}, Result: &raws[i]}
+	}
+	if err := node.BatchCallParallel(ctx, batch); err != nil {
+		return nil, err
+	}
+	blocks := make([]*chainBlock, len(numbers))
+	for i, elem := range batch {
+		if elem.Error != nil {
+			return nil, elem.Error
+		}
+		block, err := decodeBlock(raws[i])
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = block
+	}
+	return blocks, nil
+}
+
+/*
+fetchBlock function: the block named by a decimal number or a 0x-prefixed
+hash, as the block pages take it
+*/
+func fetchBlock(ctx context.Context, id string) (*chainBlock, error) {
+	if number, err := strconv.ParseUint(id, 10, 64); err == nil {
+		block, err := fetchBlockByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return nil, nodeError("Block with given number is not available in the network", err)
+		}
+		return block, nil
+	}
+	if !hashPattern.MatchString(id) {
+		return nil, invalidInput("Block must be a block number or a 0x-prefixed block hash")
+	}
+	block, err := fetchBlockByHash(ctx, common.HexToHash(id))
+	if err != nil {
+		return nil, nodeError("Block with given hash is not available in the network", err)
+	}
+	return block, nil
+}
+
+// *********************** block page ******************************************
+
+// blockTx is one transaction as the block page lists it
+type blockTx struct {
+	Hash       string     `json:"hash"`
+	From       string     `json:"from"`
+	To         string     `json:"to"` // the created contract for contract creations
+	Creation   bool       `json:"contractCreation,omitempty"`
+	ValueInEth *big.Float `json:"valueInEth"`
+	GasUsed    uint64     `json:"gasUsed"`
+	Status     string     `json:"status"`
+}
+
+// withdrawalInfo is one withdrawal as the block page lists it
+type withdrawalInfo struct {
+	Index       uint64     `json:"index"`
+	Validator   uint64     `json:"validatorIndex"`
+	Address     string     `json:"address"`
+	AmountInEth *big.Float `json:"amountInEth"`
+}
+
+/*
+blockTransactions function: lists the transactions of block with the outcome
+their receipts tell
+*/
+func blockTransactions(ctx context.Context, block *chainBlock) ([]blockTx, error) {
+	receipts, err := fetchReceipts(ctx, block.Transactions())
+	if err != nil {
+		return nil, nodeError("Reason: `eth_getTransactionReceipt` failed for the transactions of block "+block.Number().String(), err)
+	}
+	list := make([]blockTx, 0, len(receipts))
+	for i, tx := range block.Transactions() {
+		receipt := receipts[i]
+		sender, _ := types.LatestSignerForChainID(tx.ChainId()).Sender(tx)
+		entry := blockTx{
+			Hash:       tx.Hash().Hex(),
+			From:       sender.Hex(),
+			ValueInEth: weiToEther(tx.Value()),
+			Status:     "FAILED",
+		}
+		if tx.To() != nil {
+			entry.To = tx.To().Hex()
+		}
+		if receipt != nil {
+			entry.GasUsed = receipt.GasUsed
+			if receipt.Status == types.ReceiptStatusSuccessful {
+				entry.Status = "SUCCESSFUL"
+			}
+			if tx.To() == nil {
+				entry.To, entry.Creation = receipt.ContractAddress.Hex(), true
+			}
+		}
+		list = append(list, entry)
+	}
+	return list, nil
+}
+
+// blockWithdrawals lists the withdrawals of block with their amount in ether
+func blockWithdrawals(block *chainBlock) []withdrawalInfo {
+	list := make([]withdrawalInfo, 0, len(block.Withdrawals))
+	for _, w := range block.Withdrawals {
+		gwei := new(big.Int).SetUint64(uint64(w.Amount))
+		list = append(list, withdrawalInfo{
+			Index:       uint64(w.Index),
+			Validator:   uint64(w.Validator),
+			Address:     w.Address.Hex(),
+			AmountInEth: weiToEther(gwei.Mul(gwei, big.NewInt(params.GWei))),
+		})
+	}
+	return list
+}
+
+// extraDataText is extra data as text when it is printable, like a client's vanity string
+func extraDataText(extra []byte) string {
+	if len(extra) == 0 || !utf8.Valid(extra) {
+		return ""
+	}
+	for _, r := range string(extra) {
+		if !unicode.IsPrint(r) {
+			return ""
+		}
+	}
+	return string(extra)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// a London block with one dynamic fee transaction, as geth returns it
+const londonBlock = `{"baseFeePerGas":"0x342770c0","difficulty":"0x2","extraData":"0xd883010a12846765746888676f312e32372e31856c696e757800000000000000228fa8dbcd50a10f52dc9d49fab5f19921e9eafdb7bf595c9d15ba07034a7f654d61fd8bd8cc54a56c98710d03baea689b7aa29dc6c41dc3361af7e034aa207500","gasLimit":"0x1c9c380","gasUsed":"0x5208","hash":"0x961e21fc0ea15a3dc9bf8239ef35cc85e9cfbb332f3a2f9421a8888af11af628","logsBloom":"0x00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000","miner":"0x0000000000000000000000000000000000000000","mixHash":"0x0000000000000000000000000000000000000000000000000000000000000000","nonce":"0x0000000000000000","number":"0x1","parentHash":"0x4359944eeb28c6b8b98433b894b09d6cd0dc7668a1cdd6711526cc16c9778175","receiptsRoot":"0xf78dfb743fbd92ade140711c8bbc542b5e307f0ab7984eff35d751969fe57efa","sha3Uncles":"0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347","size":"0x2d4","stateRoot":"0x6ed8841e7ff2c043d92b9a96b40cf01f317802c81327db45a060594ff608fda6","timestamp":"0x6ad33992","totalDifficulty":"0x3","transactions":[{"blockHash":"0x961e21fc0ea15a3dc9bf8239ef35cc85e9cfbb332f3a2f9421a8888af11af628","blockNumber":"0x1","from":"0x344b4b3c95ed5e63529bf5d7290890b884b69c2b","gas":"0x5208","gasPrice":"0x342770c1","maxFeePerGas":"0x77359401","maxPriorityFeePerGas":"0x1","hash":"0x931c9a70c7a358d07d2cc93562949e18a23a5a8a6a7d073f742efd3433040ece","input":"0x","nonce":"0x0","to":"0x000000000000000000000000000000000000dead","transactionIndex":"0x0","value":"0x1","type":"0x2","accessList":[],"chainId":"0x539","v":"0x0","r":"0x406f3e5889e65d750bd8d47f1c04ebcd7cf2f65bfcd5e4a8d59c91665d846a1f","s":"0x381b20ab32ec2b6576721ce35f62859e1cae05866cf5d880f958a26a30f3c37e"}],"transactionsRoot":"0x19b32384a911f45255cb8a13053f831de1a54eeab8eb27fd1824eff6635dbb09","uncles":[]}`
+
+const (
+	londonBlockHash = "0x961e21fc0ea15a3dc9bf8239ef35cc85e9cfbb332f3a2f9421a8888af11af628"
+	londonTxHash    = "0x931c9a70c7a358d07d2cc93562949e18a23a5a8a6a7d073f742efd3433040ece"
+	blobTxHash      = "0x0b1b0b1b0b1b0b1b0b1b0b1b0b1b0b1b0b1b0b1b0b1b0b1b0b1b0b1b0b1b0b1b"
+)
+
+// londonBlockWith is londonBlock with its fields changed by edit
+func londonBlockWith(t *testing.T, edit func(block map[string]interface{})) json.RawMessage {
+	t.Helper()
+	var block map[string]interface{}
+	if err := json.Unmarshal([]byte(londonBlock), &block); err != nil {
+		t.Fatal(err)
+	}
+	edit(block)
+	raw, err := json.Marshal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestDecodeBlock(t *testing.T) {
+	blobTx := map[string]interface{}{
+		"type": "0x3", "hash": blobTxHash, "nonce": "0x1", "gas": "0x5208",
+		"from": "0x344b4b3c95ed5e63529bf5d7290890b884b69c2b", "to": "0x000000000000000000000000000000000000dead",
+		"maxFeePerBlobGas": "0x1", "blobVersionedHashes": []string{"0x01d18459b334ffe8e2226eef1db874fda6db2bdd9357268b39220af2ed59464f"},
+	}
+	tests := []struct {
+		name        string
+		raw         json.RawMessage
+		err         error // the error wanted
+		fails       bool  // any error wanted
+		hash        string
+		txs         []string
+		unsupported []string
+		uncles      int
+		withdrawals int
+	}{
+		{name: "null", raw: json.RawMessage(`null`), err: ethereum.NotFound},
+		{name: "nothing", raw: nil, err: ethereum.NotFound},
+		{name: "london", raw: json.RawMessage(londonBlock), hash: londonBlockHash, txs: []string{londonTxHash}},
+		{
+			name: "hash the node computes its own way",
+			raw: londonBlockWith(t, func(b map[string]interface{}) {
+				b["hash"] = "0x00000000000000000000000000000000000000000000000000000000000000aa"
+			}),
+			hash: "0x00000000000000000000000000000000000000000000000000000000000000aa",
+			txs:  []string{londonTxHash},
+		},
+		{
+			name: "pending block without hash",
+			raw:  londonBlockWith(t, func(b map[string]interface{}) { b["hash"] = nil }),
+			hash: londonBlockHash, // derived
+			txs:  []string{londonTxHash},
+		},
+		{
+			name: "empty block",
+			raw:  londonBlockWith(t, func(b map[string]interface{}) { b["transactions"] = []interface{}{} }),
+			hash: londonBlockHash,
+		},
+		{
+			name: "blob transaction",
+			raw: londonBlockWith(t, func(b map[string]interface{}) {
+				b["transactions"] = append(b["transactions"].([]interface{}), blobTx)
+			}),
+			hash:        londonBlockHash,
+			txs:         []string{londonTxHash},
+			unsupported: []string{blobTxHash},
+		},
+		{
+			name: "uncles and withdrawals",
+			raw: londonBlockWith(t, func(b map[string]interface{}) {
+				b["uncles"] = []string{londonBlockHash}
+				b["withdrawals"] = []interface{}{
+					map[string]interface{}{"index": "0x1", "validatorIndex": "0x2", "address": "0x000000000000000000000000000000000000dead", "amount": "0x3b9aca00"},
+				}
+			}),
+			hash:        londonBlockHash,
+			txs:         []string{londonTxHash},
+			uncles:      1,
+			withdrawals: 1,
+		},
+		{name: "malformed transaction", raw: londonBlockWith(t, func(b map[string]interface{}) { b["transactions"] = []interface{}{"0x01"} }), fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := decodeBlock(tt.raw)
+			switch {
+			case tt.fails:
+				if err == nil {
+					t.Fatal("decodeBlock did not fail")
+				}
+				return
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("decodeBlock error = %v, want %v", err, tt.err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("decodeBlock: %v", err)
+			}
+			if got := block.Hash().Hex(); got != tt.hash {
+				t.Errorf("hash %s, want %s", got, tt.hash)
+			}
+			var txs, unsupported []string
+			for _, tx := range block.Transactions() {
+				txs = append(txs, tx.Hash().Hex())
+			}
+			for _, tx := range block.Unsupported {
+				unsupported = append(unsupported, tx.Hash.Hex())
+				if tx.Type != 3 {
+					t.Errorf("unsupported transaction of type %d, want 3", tx.Type)
+				}
+			}
+			if !equalStrings(txs, tt.txs) || !equalStrings(unsupported, tt.unsupported) {
+				t.Errorf("transactions %v and unsupported %v, want %v and %v", txs, unsupported, tt.txs, tt.unsupported)
+			}
+			if got := block.transactionCount(); got != len(tt.txs)+len(tt.unsupported) {
+				t.Errorf("transaction count %d, want %d", got, len(tt.txs)+len(tt.unsupported))
+			}
+			if len(block.UncleHashes) != tt.uncles || len(block.Withdrawals) != tt.withdrawals {
+				t.Errorf("%d uncles and %d withdrawals, want %d and %d", len(block.UncleHashes), len(block.Withdrawals), tt.uncles, tt.withdrawals)
+			}
+		})
+	}
+}
+
+func TestDeriveBlockHash(t *testing.T) {
+	block, err := decodeBlock(json.RawMessage(londonBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := block.Header()
+	root := common.HexToHash("0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+	gas := hexutil.Uint64(0)
+
+	if got := deriveBlockHash(header, headerExtension{}); got.Hex() != londonBlockHash {
+		t.Fatalf("london hash %s, want %s as reported by the node", got.Hex(), londonBlockHash)
+	}
+	preLondon := *header
+	preLondon.BaseFee = nil
+	if got, want := deriveBlockHash(&preLondon, headerExtension{}), preLondon.Hash(); got != want {
+		t.Errorf("pre-London hash %s, want %s as go-ethereum hashes it", got.Hex(), want.Hex())
+	}
+
+	tests := []struct {
+		name string
+		ext  headerExtension
+		same string // an extension hashing the same, since the header ends before its fields
+	}{
+		{name: "shanghai", ext: headerExtension{WithdrawalsRoot: &root}},
+		{name: "cancun", ext: headerExtension{WithdrawalsRoot: &root, BlobGasUsed: &gas, ExcessBlobGas: &gas, ParentBeaconBlockRoot: &root}},
+		{name: "prague", ext: headerExtension{WithdrawalsRoot: &root, BlobGasUsed: &gas, ExcessBlobGas: &gas, ParentBeaconBlockRoot: &root, RequestsHash: &root}},
+		{name: "requests without blob fields", ext: headerExtension{WithdrawalsRoot: &root, RequestsHash: &root}, same: "shanghai"},
+		{name: "incomplete cancun", ext: headerExtension{WithdrawalsRoot: &root, BlobGasUsed: &gas}, same: "shanghai"},
+		{name: "post-london fields without base fee", ext: headerExtension{WithdrawalsRoot: &root}, same: "pre-london"},
+	}
+	hashes := map[string]common.Hash{"london": common.HexToHash(londonBlockHash), "pre-london": preLondon.Hash()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := header
+			if tt.same == "pre-london" {
+				h = &preLondon
+			}
+			got := deriveBlockHash(h, tt.ext)
+			if tt.same != "" {
+				if got != hashes[tt.same] {
+					t.Errorf("hash %s, want the %s hash %s", got.Hex(), tt.same, hashes[tt.same].Hex())
+				}
+				return
+			}
+			for name, other := range hashes {
+				if got == other {
+					t.Errorf("hash %s equals the %s hash", got.Hex(), name)
+				}
+			}
+			hashes[tt.name] = got
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/yaml.v2"
+)
+
+// *********************** configuration **************************************
+
+const (
+	CONFIG_ENV_PREFIX  = "EXPLORER_" // environment variable of a flag: EXPLORER_ and the flag in upper snake case
+	DEFAULT_LISTEN     = "0.0.0.0:5051"
+	LOCAL_LISTEN       = "127.0.0.1:5051" // default while a feature that sends transactions or drives the node is on
+	DEFAULT_NODE       = "http://0.0.0.0:8545"
+	SERVER_TIMEOUT     = 15 * time.Second // default read and write timeout of the server
+	REQUEST_MARGIN     = time.Second      // a request ends this long before the server stops writing
+	BLOCKS_IN_PAGE     = 10               // default blocks per page of the home page
+	MAX_PAGE_SIZE      = 1000
+	DEFAULT_TEMPLATES  = "template" // read by -dev when -templates is not given
+	DEFAULT_STATIC_DIR = "static"
+)
+
+// serverConfig holds every setting of the server. Each comes from, by rising
+// precedence, its default, the config file, the environment and the flags
+type serverConfig struct {
+	File string `yaml:"-"` // the config file read, empty when none
+	Dev  bool   `yaml:"dev"`
+
+	Listen       string        `yaml:"listen"`
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	TLS          struct {
+		Cert string `yaml:"cert"`
+		Key  string `yaml:"key"`
+	} `yaml:"tls"`
+
+	Node struct {
+		URL     string        `yaml:"url"`
+		Timeout time.Duration `yaml:"timeout"`
+		Retries int           `yaml:"retries"`
+	} `yaml:"node"`
+	Networks networkList `yaml:"networks"` // name -> endpoint, besides the node
+
+	Paths struct {
+		Templates  string `yaml:"templates"`
+		Static     string `yaml:"static"`
+		Index      string `yaml:"index"`
+		ABI        string `yaml:"abi"`
+		Artifacts  string `yaml:"artifacts"`
+		Signatures string `yaml:"signatures"`
+	} `yaml:"paths"`
+
+	Pages struct {
+		Blocks          int `yaml:"blocks"`
+		AddressActivity int `yaml:"addressActivity"`
+	} `yaml:"pages"`
+
+	Caches struct {
+		Traces        int `yaml:"traces"`
+		PoolSightings int `yaml:"poolSightings"`
+		LiveBacklog   int `yaml:"liveBacklog"`
+	} `yaml:"caches"`
+
+	Features struct {
+		Index    bool `yaml:"index"`
+		Live     bool `yaml:"live"`
+		DevChain bool `yaml:"devChain"`
+		Send     bool `yaml:"send"`
+		Faucet   bool `yaml:"faucet"`
+	} `yaml:"features"`
+
+	Faucet struct {
+		Funder string `yaml:"funder"`
+		Amount string `yaml:"amount"`
+	} `yaml:"faucet"`
+}
+
+// networkList is the networks setting, name=endpoint pairs separated by
+// commas on the command line and in the environment
+type networkList map[string]string
+
+var config = defaultConfig() // the settings the server runs with
+
+/*
+defaultConfig function: the settings of a server started without any
+*/
+func defaultConfig() *serverConfig {
+	cfg := &serverConfig{ReadTimeout: SERVER_TIMEOUT, WriteTimeout: SERVER_TIMEOUT}
+	cfg.Node.URL = DEFAULT_NODE
+	cfg.Node.Timeout = NODE_CALL_TIMEOUT
+	cfg.Node.Retries = NODE_RETRIES
+	cfg.Paths.Index = INDEX_PATH
+	cfg.Paths.ABI = ABI_DIR
+	cfg.Pages.Blocks = BLOCKS_IN_PAGE
+	cfg.Pages.AddressActivity = ADDRESS_ACTIVITY_IN_PAGE
+	cfg.Caches.Traces = TXDEBUG_CACHE_SIZE
+	cfg.Caches.PoolSightings = MEMPOOL_SEEN_LIMIT
+	cfg.Caches.LiveBacklog = LIVE_BACKLOG
+	cfg.Features.Index = true
+	cfg.Features.Live = true
+	cfg.Features.DevChain = true
+	cfg.Features.Send = true
+	cfg.Features.Faucet = true
+	cfg.Faucet.Amount = FAUCET_AMOUNT
+	return cfg
+}
+
+/*
+flagSet function: the command-line flags, each bound to its setting in cfg
+*/
+func (cfg *serverConfig) flagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.StringVar(&cfg.File, "config", cfg.File, "YAML config file, see config.example.yaml")
+	flags.BoolVar(&cfg.Dev, "dev", cfg.Dev, "read the templates and static files from disk on every request, for editing them")
+
+	flags.StringVar(&cfg.Listen, "listen", cfg.Listen, "address the server listens on, "+LOCAL_LISTEN+" while -enable-devchain, -enable-send or -enable-faucet is on and "+DEFAULT_LISTEN+" otherwise")
+	flags.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "limit of reading a request")
+	flags.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "limit of writing a response, page requests end a second before")
+	flags.StringVar(&cfg.TLS.Cert, "tls-cert", cfg.TLS.Cert, "certificate file, serves https together with -tls-key")
+	flags.StringVar(&cfg.TLS.Key, "tls-key", cfg.TLS.Key, "private key file of -tls-cert")
+
+	flags.StringVar(&cfg.Node.URL, "node", cfg.Node.URL, "node endpoint: http(s)://, ws(s):// or an ipc socket path (ipc://path or /path/geth.ipc)")
+	flags.DurationVar(&cfg.Node.Timeout, "node-timeout", cfg.Node.Timeout, "limit of one node call before it fails as a timeout")
+	flags.IntVar(&cfg.Node.Retries, "node-retries", cfg.Node.Retries, "retries of a node call failing with a refused connection, 5xx or rate limit")
+	flags.Var(&cfg.Networks, "networks", "more networks to switch to, as name=endpoint pairs separated by commas")
+
+	flags.StringVar(&cfg.Paths.Templates, "templates", cfg.Paths.Templates, "directory of the page templates, the built-in ones when empty")
+	flags.StringVar(&cfg.Paths.Static, "static", cfg.Paths.Static, "directory of the static assets, the built-in ones when empty")
+	flags.StringVar(&cfg.Paths.Index, "index-path", cfg.Paths.Index, "bbolt file of the block index")
+	flags.StringVar(&cfg.Paths.ABI, "abi-dir", cfg.Paths.ABI, "directory of the registered contract ABIs")
+	flags.StringVar(&cfg.Paths.Artifacts, "artifacts", cfg.Paths.Artifacts, "Truffle build/contracts or Hardhat artifacts directory whose contracts get labelled")
+	flags.StringVar(&cfg.Paths.Signatures, "signatures", cfg.Paths.Signatures, "file of extra function and event signatures, one per line, added to the bundled ones")
+
+	flags.IntVar(&cfg.Pages.Blocks, "page-blocks", cfg.Pages.Blocks, "blocks per page of the home page")
+	flags.IntVar(&cfg.Pages.AddressActivity, "page-address-activity", cfg.Pages.AddressActivity, "rows per page of the address history")
+
+	flags.IntVar(&cfg.Caches.Traces, "cache-traces", cfg.Caches.Traces, "transaction traces kept in memory for the opcode debugger")
+	flags.IntVar(&cfg.Caches.PoolSightings, "cache-pool-sightings", cfg.Caches.PoolSightings, "pool transactions whose first sighting is remembered")
+	flags.IntVar(&cfg.Caches.LiveBacklog, "cache-live-backlog", cfg.Caches.LiveBacklog, "recent live events replayed to a reconnecting browser")
+
+	flags.BoolVar(&cfg.Features.Index, "enable-index", cfg.Features.Index, "keep a block index of the -node network")
+	flags.BoolVar(&cfg.Features.Live, "enable-live", cfg.Features.Live, "push new blocks and pending transactions to the home page")
+	flags.BoolVar(&cfg.Features.DevChain, "enable-devchain", cfg.Features.DevChain, "serve the dev chain panel")
+	flags.BoolVar(&cfg.Features.Send, "enable-send", cfg.Features.Send, "serve the send transaction form")
+	flags.BoolVar(&cfg.Features.Faucet, "enable-faucet", cfg.Features.Faucet, "serve the faucet")
+
+	flags.StringVar(&cfg.Faucet.Funder, "faucet-funder", cfg.Faucet.Funder, "unlocked account the faucet of the -node network sends from, the first account of the node when empty")
+	flags.StringVar(&cfg.Faucet.Amount, "faucet-amount", cfg.Faucet.Amount, "ether the faucet sends per request")
+	return flags
+}
+
+/*
+loadConfig function: reads the settings from the config file, the environment
+and args, in that order, each overriding the one before, and validates them
+*/
+func loadConfig(args []string) (*serverConfig, error) {
+	// the file is named by a flag or the environment, the flags are read again
+	// once the file and the environment are applied
+	probe := defaultConfig()
+	probe.flagSet().Parse(args)
+	file := probe.File
+	if file == "" {
+		file = os.Getenv(envName("config"))
+	}
+
+	cfg := defaultConfig()
+	if file != "" {
+		document, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(document, cfg); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", file, err)
+		}
+		cfg.File = file
+	}
+
+	flags := cfg.flagSet()
+	var envErr error
+	flags.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(envName(f.Name)); ok && envErr == nil {
+			if err := flags.Set(f.Name, value); err != nil {
+				envErr = fmt.Errorf("%s=%q: %w", envName(f.Name), value, err)
+			}
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+	flags.Parse(args)
+	if file != "" {
+		cfg.File = file
+	}
+	// -dev edits the files of the source tree unless told otherwise
+	if cfg.Dev && cfg.Paths.Templates == "" {
+		cfg.Paths.Templates = DEFAULT_TEMPLATES
+	}
+	if cfg.Dev && cfg.Paths.Static == "" {
+		cfg.Paths.Static = DEFAULT_STATIC_DIR
+	}
+	// the dev chain panel, the send form and the faucet take no login, so
+	// they are only reachable from this machine unless -listen says otherwise
+	if cfg.Listen == "" {
+		cfg.Listen = DEFAULT_LISTEN
+		if cfg.Features.DevChain || cfg.Features.Send || cfg.Features.Faucet {
+			cfg.Listen = LOCAL_LISTEN
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// envName is the environment variable of a flag, e.g. EXPLORER_NODE_TIMEOUT
+func envName(flagName string) string {
+	return CONFIG_ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+/*
+validate function: reports the first setting the server cannot run with,
+naming its flag
+*/
+func (cfg *serverConfig) validate() error {
+	if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		return fmt.Errorf("-listen %q: %w", cfg.Listen, err)
+	}
+	if cfg.ReadTimeout <= 0 {
+		return fmt.Errorf("-read-timeout %s must be positive", cfg.ReadTimeout)
+	}
+	if cfg.WriteTimeout <= REQUEST_MARGIN {
+		return fmt.Errorf("-write-timeout %s must be longer than %s", cfg.WriteTimeout, REQUEST_MARGIN)
+	}
+	if (cfg.TLS.Cert == "") != (cfg.TLS.Key == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	for _, file := range []struct{ flag, path string }{{"-tls-cert", cfg.TLS.Cert}, {"-tls-key", cfg.TLS.Key}, {"-signatures", cfg.Paths.Signatures}} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			return fmt.Errorf("%s: %w", file.flag, err)
+		}
+	}
+
+	if err := validEndpoint(cfg.Node.URL); err != nil {
+		return fmt.Errorf("-node: %w", err)
+	}
+	if cfg.Node.Timeout <= 0 {
+		return fmt.Errorf("-node-timeout %s must be positive", cfg.Node.Timeout)
+	}
+	if cfg.Node.Retries < 0 {
+		return fmt.Errorf("-node-retries %d must not be negative", cfg.Node.Retries)
+	}
+	for _, name := range cfg.Networks.names() {
+		if name == DEFAULT_NETWORK {
+			return fmt.Errorf("-networks: %s is the name of the -node network", name)
+		}
+		if !networkNamePattern.MatchString(name) {
+			return fmt.Errorf("-networks: name %q must be lowercase letters, digits and dashes", name)
+		}
+		if err := validEndpoint(cfg.Networks[name]); err != nil {
+			return fmt.Errorf("-networks: %s: %w", name, err)
+		}
+	}
+
+	for _, dir := range []struct{ flag, path string }{{"-templates", cfg.Paths.Templates}, {"-static", cfg.Paths.Static}, {"-artifacts", cfg.Paths.Artifacts}} {
+		if dir.path == "" {
+			continue
+		}
+		if info, err := os.Stat(dir.path); err != nil {
+			return fmt.Errorf("%s: %w", dir.flag, err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s: %s is not a directory", dir.flag, dir.path)
+		}
+	}
+	if cfg.Paths.Templates != "" {
+		if _, err := os.Stat(filepath.Join(cfg.Paths.Templates, "index.html")); err != nil {
+			return fmt.Errorf("-templates: %s holds no page templates: %w", cfg.Paths.Templates, err)
+		}
+	}
+	if cfg.Paths.Index == "" && cfg.Features.Index {
+		return errors.New("-index-path must name a file while -enable-index is set")
+	}
+	// -index-path=false is a mistyped -enable-index=false, not a file to create
+	if path := strings.ToLower(cfg.Paths.Index); path == "true" || path == "false" {
+		return fmt.Errorf("-index-path %q is not a file name, switch the index with -enable-index", cfg.Paths.Index)
+	}
+	if cfg.Paths.ABI == "" {
+		return errors.New("-abi-dir must name a directory")
+	}
+
+	for _, size := range []struct {
+		flag  string
+		value int
+	}{
+		{"-page-blocks", cfg.Pages.Blocks},
+		{"-page-address-activity", cfg.Pages.AddressActivity},
+	} {
+		if size.value < 1 || size.value > MAX_PAGE_SIZE {
+			return fmt.Errorf("%s %d must be between 1 and %d", size.flag, size.value, MAX_PAGE_SIZE)
+		}
+	}
+	for _, size := range []struct {
+		flag  string
+		value int
+	}{
+		{"-cache-traces", cfg.Caches.Traces},
+		{"-cache-pool-sightings", cfg.Caches.PoolSightings},
+		{"-cache-live-backlog", cfg.Caches.LiveBacklog},
+	} {
+		if size.value < 1 {
+			return fmt.Errorf("%s %d must be at least 1", size.flag, size.value)
+		}
+	}
+
+	if cfg.Faucet.Funder != "" && !common.IsHexAddress(cfg.Faucet.Funder) {
+		return fmt.Errorf("-faucet-funder %q is not an address", cfg.Faucet.Funder)
+	}
+	if _, err := etherToWei(cfg.Faucet.Amount); err != nil {
+		return fmt.Errorf("-faucet-amount %q: %w", cfg.Faucet.Amount, err)
+	}
+	return nil
+}
+
+// validEndpoint checks the scheme of a node endpoint, the node is dialled later
+func validEndpoint(url string) error {
+	switch {
+	case url == "":
+		return errors.New("no endpoint given")
+	case strings.HasPrefix(url, "http://"), strings.HasPrefix(url, "https://"), isWebsocketEndpoint(url), isIPCEndpoint(url):
+		return nil
+	}
+	return fmt.Errorf("%q is not an http(s)://, ws(s):// or ipc endpoint", url)
+}
+
+/*
+apply function: hands the settings to the parts of the server that keep their
+own copy
+*/
+func (cfg *serverConfig) apply() {
+	config = cfg
+	nodeCallTimeout = cfg.Node.Timeout
+	nodeRetries = cfg.Node.Retries
+	requestTimeout = cfg.WriteTimeout - REQUEST_MARGIN
+	contractABIs.dir = cfg.Paths.ABI
+	faucetAmount = cfg.Faucet.Amount
+}
+
+// enabled reports whether a feature is switched on, for the templates
+func (cfg *serverConfig) enabled(feature string) bool {
+	switch feature {
+	case "index":
+		return cfg.Features.Index
+	case "live":
+		return cfg.Features.Live
+	case "devChain":
+		return cfg.Features.DevChain
+	case "send":
+		return cfg.Features.Send
+	case "faucet":
+		return cfg.Features.Faucet
+	}
+	return false
+}
+
+func (list networkList) names() []string {
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (list *networkList) String() string {
+	if list == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*list))
+	for _, name := range list.names() {
+		pairs = append(pairs, name+"="+(*list)[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+/*
+Set function: parses name=endpoint pairs separated by commas, replacing the
+networks of the config file
+*/
+func (list *networkList) Set(value string) error {
+	parsed := make(networkList)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, url, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q is not a name=endpoint pair", pair)
+		}
+		name = strings.TrimSpace(name)
+		if _, ok := parsed[name]; ok {
+			return fmt.Errorf("network %s is given twice", name)
+		}
+		parsed[name] = strings.TrimSpace(url)
+	}
+	*list = parsed
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	type settings struct {
+		Listen     string
+		Node       string
+		PageBlocks int
+		Send       bool
+		Networks   networkList
+	}
+	tests := []struct {
+		name string
+		file string            // config file content, no file when empty
+		env  map[string]string // each case also runs with the file named by EXPLORER_CONFIG
+		args []string
+		want settings
+	}{
+		{
+			name: "defaults",
+			want: settings{Listen: LOCAL_LISTEN, Node: DEFAULT_NODE, PageBlocks: BLOCKS_IN_PAGE, Send: true},
+		},
+		{
+			name: "file over defaults",
+			file: "node:\n  url: ws://127.0.0.1:8546\npages:\n  blocks: 20\n",
+			want: settings{Listen: LOCAL_LISTEN, Node: "ws://127.0.0.1:8546", PageBlocks: 20, Send: true},
+		},
+		{
+			name: "environment over file",
+			file: "node:\n  url: ws://127.0.0.1:8546\npages:\n  blocks: 20\n",
+			env:  map[string]string{"EXPLORER_PAGE_BLOCKS": "30", "EXPLORER_NETWORKS": "sepolia=https://rpc.sepolia.example"},
+			want: settings{Listen: LOCAL_LISTEN, Node: "ws://127.0.0.1:8546", PageBlocks: 30, Send: true, Networks: networkList{"sepolia": "https://rpc.sepolia.example"}},
+		},
+		{
+			name: "flags over environment and file",
+			file: "pages:\n  blocks: 20\n",
+			env:  map[string]string{"EXPLORER_PAGE_BLOCKS": "30", "EXPLORER_NODE": "http://10.0.0.1:8545"},
+			args: []string{"-page-blocks", "40"},
+			want: settings{Listen: LOCAL_LISTEN, Node: "http://10.0.0.1:8545", PageBlocks: 40, Send: true},
+		},
+		{
+			name: "public listen once the state-changing features are off",
+			file: "features:\n  devChain: false\n  send: false\n  faucet: false\n",
+			want: settings{Listen: DEFAULT_LISTEN, Node: DEFAULT_NODE, PageBlocks: BLOCKS_IN_PAGE},
+		},
+		{
+			name: "local listen while one of them is on",
+			file: "features:\n  devChain: false\n  send: false\n  faucet: false\n",
+			env:  map[string]string{"EXPLORER_ENABLE_FAUCET": "true"},
+			want: settings{Listen: LOCAL_LISTEN, Node: DEFAULT_NODE, PageBlocks: BLOCKS_IN_PAGE},
+		},
+		{
+			name: "listen as given",
+			args: []string{"-listen", "0.0.0.0:8080"},
+			want: settings{Listen: "0.0.0.0:8080", Node: DEFAULT_NODE, PageBlocks: BLOCKS_IN_PAGE, Send: true},
+		},
+	}
+	for _, tt := range tests {
+		for _, fileFromEnv := range []bool{false, true} {
+			if fileFromEnv && tt.file == "" {
+				continue
+			}
+			name := tt.name
+			if fileFromEnv {
+				name += ", file named by the environment"
+			}
+			t.Run(name, func(t *testing.T) {
+				args := tt.args
+				if tt.file != "" {
+					path := writeConfigFile(t, tt.file)
+					if fileFromEnv {
+						t.Setenv("EXPLORER_CONFIG", path)
+					} else {
+						args = append([]string{"-config", path}, args...)
+					}
+				}
+				for key, value := range tt.env {
+					t.Setenv(key, value)
+				}
+				cfg, err := loadConfig(args)
+				if err != nil {
+					t.Fatalf("loadConfig: %v", err)
+				}
+				got := settings{cfg.Listen, cfg.Node.URL, cfg.Pages.Blocks, cfg.Features.Send, cfg.Networks}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %+v, want %+v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string // part of the error
+	}{
+		{name: "unknown key in the file", file: "pages:\n  rows: 5\n", want: "field rows not found"},
+		{name: "bad environment value", env: map[string]string{"EXPLORER_PAGE_BLOCKS": "many"}, want: "EXPLORER_PAGE_BLOCKS"},
+		{name: "invalid flag value", args: []string{"-page-blocks", "0"}, want: "-page-blocks 0 must be between 1 and"},
+		{name: "invalid file value", file: "node:\n  url: tcp://127.0.0.1:8545\n", want: "-node:"},
+		{name: "flag fixes the file", file: "pages:\n  blocks: 0\n", args: []string{"-page-blocks", "5"}},
+		{name: "bad listen", args: []string{"-listen", "5051"}, want: "-listen"},
+		{name: "index path taken for the switch", args: []string{"-index-path", "false"}, want: "-index-path \"false\" is not a file name"},
+		{name: "index path taken for the switch in the file", file: "paths:\n  index: \"True\"\n", want: "-index-path \"True\""},
+		{name: "index path", args: []string{"-index-path", "chain.db"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, tt.file)}, args...)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := loadConfig(args)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("loadConfig: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Fatalf("loadConfig error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "explorer.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gorilla/mux"
+)
+
+// *********************** dev chain control **************************************
+
+// actions of the dev chain panel
+const (
+	DEVCHAIN_MINE               = "mine"
+	DEVCHAIN_INCREASE_TIME      = "increaseTime"
+	DEVCHAIN_SET_NEXT_TIMESTAMP = "setNextBlockTimestamp"
+	DEVCHAIN_SNAPSHOT           = "snapshot"
+	DEVCHAIN_REVERT             = "revert"
+	DEVCHAIN_SET_BALANCE        = "setBalance"
+	DEVCHAIN_SET_CODE           = "setCode"
+	DEVCHAIN_IMPERSONATE        = "impersonate"
+	DEVCHAIN_STOP_IMPERSONATING = "stopImpersonating"
+
+	DEVCHAIN_MAX_MINE = 1000 // blocks mined by one request at most
+)
+
+// account methods of the dev chains, tried in order until the node has one;
+// anvil answers to the hardhat_ names too, ganache has its own evm_ ones
+var devChainMethods = map[string][]string{
+	DEVCHAIN_SET_BALANCE:        {"hardhat_setBalance", "anvil_setBalance", "evm_setAccountBalance"},
+	DEVCHAIN_SET_CODE:           {"hardhat_setCode", "anvil_setCode", "evm_setAccountCode"},
+	DEVCHAIN_IMPERSONATE:        {"hardhat_impersonateAccount", "anvil_impersonateAccount"},
+	DEVCHAIN_STOP_IMPERSONATING: {"hardhat_stopImpersonatingAccount", "anvil_stopImpersonatingAccount"},
+}
+
+// chainSnapshot is a state saved with evm_snapshot
+type chainSnapshot struct {
+	ID      string    `json:"id"`
+	Label   string    `json:"label,omitempty"`
+	Block   uint64    `json:"block"` // head when it was taken
+	TakenAt time.Time `json:"takenAt"`
+}
+
+// devChainState is what the panel remembers of each node: the node keeps no
+// list of its snapshots nor of the impersonated accounts
+type devChainState struct {
+	mu           sync.Mutex
+	snapshots    map[string][]chainSnapshot // by host, oldest first
+	impersonated map[string][]common.Address
+}
+
+var devChain = &devChainState{
+	snapshots:    make(map[string][]chainSnapshot),
+	impersonated: make(map[string][]common.Address),
+}
+
+// devChainPage is the content of /admin
+type devChainPage struct {
+	Host         string           `json:"host"`
+	Block        uint64           `json:"block"`
+	Timestamp    time.Time        `json:"timestamp"` // of the head block
+	Message      string           `json:"message,omitempty"`
+	Error        string           `json:"error,omitempty"`
+	Snapshots    []chainSnapshot  `json:"snapshots"`
+	Impersonated []common.Address `json:"impersonated"`
+}
+
+/*
+runDevChainAction function: performs one action of the panel on the node of
+the request's network with the values of the submitted form, and describes the outcome
+*/
+func runDevChainAction(ctx context.Context, r *http.Request) (string, error) {
+	host := networkOf(ctx).URL
+	node := networkOf(ctx).rpc
+	action := r.FormValue("action")
+	field := func(name string) string {
+		return strings.TrimSpace(r.FormValue(name))
+	}
+
+	switch action {
+	case DEVCHAIN_MINE:
+		blocks := 1
+		if value := field("blocks"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > DEVCHAIN_MAX_MINE {
+				return "", invalidInput(fmt.Sprintf("Blocks to mine must be a number from 1 to %d", DEVCHAIN_MAX_MINE))
+			}
+			blocks = n
+		}
+		for i := 0; i < blocks; i++ {
+			if err := node.call(ctx, "evm_mine", nil); err != nil {
+				return "", nodeError(fmt.Sprintf("Reason: `evm_mine` failed after %d of %d blocks", i, blocks), err)
+			}
+		}
+		return fmt.Sprintf("Mined %d block(s)", blocks), nil
+
+	case DEVCHAIN_INCREASE_TIME:
+		seconds, err := strconv.ParseUint(field("seconds"), 10, 63)
+		if err != nil {
+			return "", invalidInput("Seconds must be a positive number")
+		}
+		var offset json.RawMessage
+		if err := node.call(ctx, "evm_increaseTime", &offset, seconds); err != nil {
+			return "", nodeError("Reason: `evm_increaseTime` failed", err)
+		}
+		return fmt.Sprintf("Moved the clock %s forward, the next block is mined with it", time.Duration(seconds)*time.Second), nil
+
+	case DEVCHAIN_SET_NEXT_TIMESTAMP:
+		timestamp, err := parseTimestamp(field("timestamp"))
+		if err != nil {
+			return "", err
+		}
+		if err := node.call(ctx, "evm_setNextBlockTimestamp", nil, timestamp.Unix()); err != nil {
+			return "", nodeError("Reason: `evm_setNextBlockTimestamp` failed", err)
+		}
+		return "The next block is mined at " + timestamp.UTC().String(), nil
+
+	case DEVCHAIN_SNAPSHOT:
+		var id string
+		if err := node.call(ctx, "evm_snapshot", &id); err != nil {
+			return "", nodeError("Reason: `evm_snapshot` failed", err)
+		}
+		var head hexutil.Uint64
+		if err := node.call(ctx, "eth_blockNumber", &head); err != nil {
+			return "", nodeError("Reason: `eth_blockNumber` failed", err)
+		}
+		devChain.saveSnapshot(host, chainSnapshot{ID: id, Label: field("label"), Block: uint64(head), TakenAt: time.Now()})
+		return fmt.Sprintf("Saved snapshot %s at block %d", id, head), nil
+
+	case DEVCHAIN_REVERT:
+		id := field("id")
+		snapshot, ok := devChain.snapshot(host, id)
+		if !ok {
+			return "", notFound("No snapshot "+id+" was saved on "+host, nil)
+		}
+		var reverted bool
+		if err := node.call(ctx, "evm_revert", &reverted, id); err != nil {
+			return "", nodeError("Reason: `evm_revert` failed", err)
+		}
+		// reverting consumes the snapshot and every later one, also when the
+		// node no longer knew it
+		devChain.dropSnapshots(host, id)
+		if !reverted {
+			return "", &explorerError{Kind: ErrNotFound, Message: "The node no longer has snapshot " + id + ", e.g. after a restart"}
+		}
+		return fmt.Sprintf("Reverted to snapshot %s of block %d", id, snapshot.Block), nil
+
+	case DEVCHAIN_SET_BALANCE:
+		account, err := parseAccount(field("address"))
+		if err != nil {
+			return "", err
+		}
+		wei, err := etherToWei(field("ether"))
+		if err != nil {
+			return "", err
+		}
+		if err := callDevChainMethod(ctx, node, action, account, (*hexutil.Big)(wei)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Set the balance of %s to %s ETH", account.Hex(), field("ether")), nil
+
+	case DEVCHAIN_SET_CODE:
+		account, err := parseAccount(field("address"))
+		if err != nil {
+			return "", err
+		}
+		code, err := hexutil.Decode(field("code"))
+		if err != nil {
+			return "", invalidInput("Code must be 0x-prefixed hex bytecode")
+		}
+		if err := callDevChainMethod(ctx, node, action, account, hexutil.Bytes(code)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Replaced the code of %s (%d bytes)", account.Hex(), len(code)), nil
+
+	case DEVCHAIN_IMPERSONATE, DEVCHAIN_STOP_IMPERSONATING:
+		account, err := parseAccount(field("address"))
+		if err != nil {
+			return "", err
+		}
+		if err := callDevChainMethod(ctx, node, action, account); err != nil {
+			return "", err
+		}
+		if action == DEVCHAIN_IMPERSONATE {
+			devChain.impersonate(host, account, true)
+			return "Transactions from " + account.Hex() + " are now accepted without its key", nil
+		}
+		devChain.impersonate(host, account, false)
+		return "Stopped impersonating " + account.Hex(), nil
+	}
+	return "", invalidInput("Unknown dev chain action " + strconv.Quote(action))
+}
+
+/*
+callDevChainMethod function: calls the first method of action the node offers,
+see devChainMethods
+*/
+func callDevChainMethod(ctx context.Context, node *EthRPC, action string, params ...interface{}) error {
+	methods := devChainMethods[action]
+	for _, method := range methods {
+		err := node.call(ctx, method, nil, params...)
+		if err == nil {
+			return nil
+		}
+		if !isMethodUnsupported(err) {
+			return nodeError("Reason: `"+method+"` failed", err)
+		}
+	}
+	return &explorerError{Kind: ErrUpstream, Message: "The node offers none of " + strings.Join(methods, ", ")}
+}
+
+func parseAccount(address string) (common.Address, error) {
+	if !common.IsHexAddress(address) {
+		return common.Address{}, invalidInput("Address must be a 0x-prefixed 20 byte hex string")
+	}
+	return common.HexToAddress(address), nil
+}
+
+/*
+parseTimestamp function: reads unix seconds or the value of a datetime-local
+input, taken as UTC
+*/
+func parseTimestamp(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds > 0 {
+		return time.Unix(seconds, 0), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if timestamp, err := time.Parse(layout, value); err == nil {
+			return timestamp, nil
+		}
+	}
+	return time.Time{}, invalidInput("Timestamp must be unix seconds or a date like 2024-01-31T12:00")
+}
+
+func (s *devChainState) saveSnapshot(host string, snapshot chainSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots[host] = append(s.snapshots[host], snapshot)
+}
+
+func (s *devChainState) snapshot(host, id string) (chainSnapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, snapshot := range s.snapshots[host] {
+		if snapshot.ID == id {
+			return snapshot, true
+		}
+	}
+	return chainSnapshot{}, false
+}
+
+// dropSnapshots forgets snapshot id of host and the ones taken after it
+func (s *devChainState) dropSnapshots(host, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, snapshot := range s.snapshots[host] {
+		if snapshot.ID == id {
+			s.snapshots[host] = s.snapshots[host][:i]
+			return
+		}
+	}
+}
+
+func (s *devChainState) impersonate(host string, account common.Address, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accounts := s.impersonated[host][:0:0]
+	for _, known := range s.impersonated[host] {
+		if known != account {
+			accounts = append(accounts, known)
+		}
+	}
+	if on {
+		accounts = append(accounts, account)
+	}
+	s.impersonated[host] = accounts
+}
+
+/*
+loadDevChain function: the head of the node of the request's network with the snapshots
+and impersonated accounts the panel remembers for it
+*/
+func loadDevChain(ctx context.Context) (devChainPage, error) {
+	host := networkOf(ctx).URL
+	head, err := nodeOf(ctx).HeaderByNumber(ctx, nil)
+	if err != nil {
+		return devChainPage{}, nodeError("Reason:`@HeaderByNumber` failed. Make sure GANACHE runs @ "+host, err)
+	}
+	devChain.mu.Lock()
+	defer devChain.mu.Unlock()
+	data := devChainPage{
+		Host:         host,
+		Block:        head.Number.Uint64(),
+		Timestamp:    time.Unix(int64(head.Time), 0),
+		Snapshots:    append([]chainSnapshot{}, devChain.snapshots[host]...),
+		Impersonated: append([]common.Address{}, devChain.impersonated[host]...),
+	}
+	// newest first, the one to revert to is usually the last taken
+	for i, j := 0, len(data.Snapshots)-1; i < j; i, j = i+1, j-1 {
+		data.Snapshots[i], data.Snapshots[j] = data.Snapshots[j], data.Snapshots[i]
+	}
+	return data, nil
+}
+
+/*
+devChainHandler function: serves /admin, the dev chain control panel; a POST
+runs one action and shows its outcome above the panel
+*/
+func devChainHandler(w http.ResponseWriter, r *http.Request) error {
+	var message, failure string
+	if r.Method == http.MethodPost {
+		var err error
+		if message, err = runDevChainAction(r.Context(), r); err != nil {
+			failure = err.Error() // shown above the panel, which stays usable
+		}
+	}
+	data, err := loadDevChain(r.Context())
+	if err != nil {
+		return err
+	}
+	data.Message, data.Error = message, failure
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "admin.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+/*
+apiDevChain function: GET /api/v1/admin returns the panel state, POST
+/api/v1/admin/{action} runs an action with the form values of the request
+*/
+func apiDevChain(w http.ResponseWriter, r *http.Request) error {
+	var message string
+	if action, ok := mux.Vars(r)["action"]; ok {
+		if err := r.ParseForm(); err != nil {
+			return invalidInput("Couldn't able to read the form values")
+		}
+		r.Form.Set("action", action)
+		var err error
+		if message, err = runDevChainAction(r.Context(), r); err != nil {
+			return err
+		}
+	}
+	data, err := loadDevChain(r.Context())
+	if err != nil {
+		return err
+	}
+	data.Message = message
+	return writeJSON(w, http.StatusOK, data)
+}
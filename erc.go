@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"math/big"
 	"strings"
 
@@ -13,27 +14,71 @@ import (
 )
 
 const (
-	ERC20_ABI                = `[{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"payable":false,"stateMutability":"view","type":"function"}]`
-	ERC20_TRANSFER_SIGNATURE = "Transfer(address,address,uint256)"
+	ERC20_ABI = `[{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"payable":false,"stateMutability":"view","type":"function"},` +
+		`{"constant":true,"inputs":[{"name":"owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},` +
+		`{"constant":true,"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"payable":false,"stateMutability":"view","type":"function"},` +
+		`{"constant":true,"inputs":[],"name":"name","outputs":[{"name":"","type":"string"}],"payable":false,"stateMutability":"view","type":"function"},` +
+		`{"constant":true,"inputs":[],"name":"totalSupply","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"}]`
+	ERC20_TRANSFER_SIGNATURE          = "Transfer(address,address,uint256)" // ERC-721 shares it, with the token id indexed
+	ERC1155_TRANSFER_SINGLE_SIGNATURE = "TransferSingle(address,address,address,uint256,uint256)"
+	ERC1155_TRANSFER_BATCH_SIGNATURE  = "TransferBatch(address,address,address,uint256[],uint256[])"
 )
 
+var (
+	erc20ABI = mustParseABI(ERC20_ABI)
+
+	erc20TransferTopic         = crypto.Keccak256Hash([]byte(ERC20_TRANSFER_SIGNATURE))
+	erc1155TransferSingleTopic = crypto.Keccak256Hash([]byte(ERC1155_TRANSFER_SINGLE_SIGNATURE))
+	erc1155TransferBatchTopic  = crypto.Keccak256Hash([]byte(ERC1155_TRANSFER_BATCH_SIGNATURE))
+)
+
+// TokenHolding is one ERC-20 an address has received, with its current balance
+type TokenHolding struct {
+	Contract         string     `json:"contract"`
+	Name             string     `json:"name"`
+	Symbol           string     `json:"symbol"`
+	Decimals         int64      `json:"decimals"`
+	Balance          *big.Int   `json:"balance"`
+	BalanceFormatted *big.Float `json:"balanceFormatted"`
+}
+
+// NFTItem is one token id moved by an NFT transfer; Amount is always 1 for ERC-721
+type NFTItem struct {
+	TokenID *big.Int `json:"tokenId"`
+	Amount  *big.Int `json:"amount"`
+}
+
+// NFTTransferLog is a decoded ERC-721 Transfer or ERC-1155 TransferSingle/TransferBatch
+type NFTTransferLog struct {
+	Standard string    `json:"standard"` // ERC-721 or ERC-1155
+	Contract string    `json:"contract"`
+	Operator string    `json:"operator,omitempty"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Items    []NFTItem `json:"items"`
+}
+
 type TokenTransferLog struct {
-	Contract    string
-	From        string
-	To          string
-	Amount      *big.Int
-	AmountInEth *big.Float
+	Contract    string     `json:"contract"`
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	Amount      *big.Int   `json:"amount"`
+	AmountInEth *big.Float `json:"amountInEth"`
 }
 
-func GetTokenDecimals(tokenAddress common.Address) (int64, error) {
-	parsedABI, err := abi.JSON(strings.NewReader(ERC20_ABI))
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
 	if err != nil {
-		return 0, err
+		panic(err)
 	}
+	return parsed
+}
 
-	callData, err := parsedABI.Pack("decimals")
+// callTokenRaw calls a read-only ERC-20 method and returns the undecoded result
+func callTokenRaw(ctx context.Context, tokenAddress common.Address, method string, args ...interface{}) ([]byte, error) {
+	callData, err := erc20ABI.Pack(method, args...)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	msg := ethereum.CallMsg{
@@ -41,36 +86,154 @@ func GetTokenDecimals(tokenAddress common.Address) (int64, error) {
 		Data: callData,
 	}
 
-	result, err := client.CallContract(context.Background(), msg, nil)
+	return nodeOf(ctx).CallContract(ctx, msg, nil)
+}
+
+// callToken calls a read-only ERC-20 method and unpacks its single result into out
+func callToken(ctx context.Context, tokenAddress common.Address, method string, out interface{}, args ...interface{}) error {
+	result, err := callTokenRaw(ctx, tokenAddress, method, args...)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
+	return erc20ABI.UnpackIntoInterface(out, method, result)
+}
+
+func GetTokenDecimals(ctx context.Context, tokenAddress common.Address) (int64, error) {
 	var decimals uint8
-	err = parsedABI.UnpackIntoInterface(&decimals, "decimals", result)
-	if err != nil {
+	if err := callToken(ctx, tokenAddress, "decimals", &decimals); err != nil {
 		return 0, err
 	}
-
 	return int64(decimals), nil
 }
 
+func GetTokenBalance(ctx context.Context, tokenAddress common.Address, owner common.Address) (*big.Int, error) {
+	balance := new(big.Int)
+	if err := callToken(ctx, tokenAddress, "balanceOf", &balance, owner); err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+func GetTokenSymbol(ctx context.Context, tokenAddress common.Address) (string, error) {
+	var symbol string
+	err := callToken(ctx, tokenAddress, "symbol", &symbol)
+	return symbol, err
+}
+
+func GetTokenName(ctx context.Context, tokenAddress common.Address) (string, error) {
+	var name string
+	err := callToken(ctx, tokenAddress, "name", &name)
+	return name, err
+}
+
 func Base10Power(power int64) *big.Float {
 	return new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(power), nil))
 }
 
-func ParseTokenAmount(tokenContractAddress string, amountInWei *big.Int) *big.Float {
-	if decimals, err := GetTokenDecimals(common.HexToAddress(tokenContractAddress)); err == nil {
-		return new(big.Float).Quo(new(big.Float).SetInt(amountInWei), Base10Power(decimals))
+func ScaleTokenAmount(amountInWei *big.Int, decimals int64) *big.Float {
+	return new(big.Float).Quo(new(big.Float).SetInt(amountInWei), Base10Power(decimals))
+}
+
+// ParseTokenAmount scales amountInWei by the decimals the token registry knows for the contract
+func ParseTokenAmount(ctx context.Context, tokenContractAddress string, amountInWei *big.Int) *big.Float {
+	if token, err := resolveToken(ctx, common.HexToAddress(tokenContractAddress), STANDARD_ERC20); err == nil {
+		return ScaleTokenAmount(amountInWei, token.Decimals)
 	}
 	return big.NewFloat(0)
 }
 
-func ExtractReceiptLogs(receipt *types.Receipt) []TokenTransferLog {
+// IsTokenTransfer reports whether log is an ERC-20 Transfer: only from and to are indexed
+func IsTokenTransfer(log *types.Log) bool {
+	return len(log.Topics) == 3 && log.Topics[0] == erc20TransferTopic
+}
+
+// IsERC721Transfer reports whether log is an ERC-721 Transfer: the token id is indexed too
+func IsERC721Transfer(log *types.Log) bool {
+	return len(log.Topics) == 4 && log.Topics[0] == erc20TransferTopic
+}
+
+// IsERC1155Transfer reports whether log is an ERC-1155 TransferSingle or TransferBatch
+func IsERC1155Transfer(log *types.Log) bool {
+	return len(log.Topics) == 4 && (log.Topics[0] == erc1155TransferSingleTopic || log.Topics[0] == erc1155TransferBatchTopic)
+}
+
+// GetTokenHolding resolves the metadata of token and the balance owner holds of it
+func GetTokenHolding(ctx context.Context, tokenAddress common.Address, owner common.Address) (TokenHolding, error) {
+	balance, err := GetTokenBalance(ctx, tokenAddress, owner)
+	if err != nil {
+		return TokenHolding{}, err
+	}
+	token, err := resolveToken(ctx, tokenAddress, STANDARD_ERC20)
+	if err != nil {
+		return TokenHolding{}, err
+	}
+	return TokenHolding{
+		Contract:         tokenAddress.Hex(),
+		Name:             token.Name,
+		Symbol:           token.Symbol,
+		Decimals:         token.Decimals,
+		Balance:          balance,
+		BalanceFormatted: ScaleTokenAmount(balance, token.Decimals),
+	}, nil
+}
+
+/*
+tokensReceivedFromNode function: asks the node for every Transfer log to
+address and returns the distinct token contracts, in order of first receipt
+*/
+func tokensReceivedFromNode(ctx context.Context, address common.Address) ([]common.Address, error) {
+	logs, err := nodeOf(ctx).FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: big.NewInt(0),
+		Topics:    [][]common.Hash{{erc20TransferTopic}, nil, {common.BytesToHash(address.Bytes())}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var tokens []common.Address
+	seen := make(map[common.Address]bool)
+	for i := range logs {
+		if IsTokenTransfer(&logs[i]) && !seen[logs[i].Address] {
+			seen[logs[i].Address] = true
+			tokens = append(tokens, logs[i].Address)
+		}
+	}
+	return tokens, nil
+}
+
+/*
+loadTokenPortfolio function: lists every ERC-20 address ever received, with
+its metadata and current balance
+*/
+func loadTokenPortfolio(ctx context.Context, address common.Address) ([]TokenHolding, error) {
+	var tokens []common.Address
+	var err error
+	if idx := activeIndex(ctx); idx != nil {
+		tokens, err = idx.tokensReceived(address)
+	} else {
+		tokens, err = tokensReceivedFromNode(ctx, address)
+	}
+	if err != nil {
+		return nil, nodeError("Couldn't able to list the tokens received by "+address.Hex(), err)
+	}
+
+	holdings := make([]TokenHolding, 0, len(tokens))
+	for _, token := range tokens {
+		holding, err := GetTokenHolding(ctx, token, address)
+		if err != nil {
+			// not an ERC-20 after all (e.g. an NFT without balanceOf semantics we understand)
+			continue
+		}
+		holdings = append(holdings, holding)
+	}
+	return holdings, nil
+}
+
+func ExtractReceiptLogs(ctx context.Context, receipt *types.Receipt) []TokenTransferLog {
 	var logs []TokenTransferLog
 	for _, log := range receipt.Logs {
 
-		if len(log.Topics) >= 3 && log.Topics[0] == crypto.Keccak256Hash([]byte(ERC20_TRANSFER_SIGNATURE)) {
+		if IsTokenTransfer(log) {
 			tokenContract := log.Address.Hex()
 			from := common.HexToAddress(log.Topics[1].Hex()).Hex()
 			to := common.HexToAddress(log.Topics[2].Hex()).Hex()
@@ -83,7 +246,7 @@ func ExtractReceiptLogs(receipt *types.Receipt) []TokenTransferLog {
 				From:        from,
 				To:          to,
 				Amount:      amount,
-				AmountInEth: ParseTokenAmount(tokenContract, amount),
+				AmountInEth: ParseTokenAmount(ctx, tokenContract, amount),
 			}
 			logs = append(logs, tokenTransfer)
 		}
@@ -91,3 +254,88 @@ func ExtractReceiptLogs(receipt *types.Receipt) []TokenTransferLog {
 
 	return logs
 }
+
+func topicAddress(topic common.Hash) string {
+	return common.BytesToAddress(topic.Bytes()).Hex()
+}
+
+// decodeERC1155Batch unpacks the ids and values arrays of a TransferBatch
+func decodeERC1155Batch(data []byte) ([]NFTItem, error) {
+	uintArray, err := abi.NewType("uint256[]", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	values, err := abi.Arguments{{Type: uintArray}, {Type: uintArray}}.Unpack(data)
+	if err != nil {
+		return nil, err
+	}
+	ids, amounts := values[0].([]*big.Int), values[1].([]*big.Int)
+	if len(ids) != len(amounts) {
+		return nil, errors.New("ids and values differ in length")
+	}
+	items := make([]NFTItem, len(ids))
+	for i := range ids {
+		items[i] = NFTItem{TokenID: ids[i], Amount: amounts[i]}
+	}
+	return items, nil
+}
+
+/*
+nftTransferOf function: decodes one ERC-721 or ERC-1155 transfer log; ok is
+false for any other log or a malformed one
+*/
+func nftTransferOf(log *types.Log) (transfer NFTTransferLog, ok bool) {
+	switch {
+	case IsERC721Transfer(log):
+		return NFTTransferLog{
+			Standard: STANDARD_ERC721,
+			Contract: log.Address.Hex(),
+			From:     topicAddress(log.Topics[1]),
+			To:       topicAddress(log.Topics[2]),
+			Items:    []NFTItem{{TokenID: log.Topics[3].Big(), Amount: big.NewInt(1)}},
+		}, true
+
+	case IsERC1155Transfer(log):
+		transfer = NFTTransferLog{
+			Standard: STANDARD_ERC1155,
+			Contract: log.Address.Hex(),
+			Operator: topicAddress(log.Topics[1]),
+			From:     topicAddress(log.Topics[2]),
+			To:       topicAddress(log.Topics[3]),
+		}
+		if log.Topics[0] == erc1155TransferSingleTopic {
+			if len(log.Data) != 64 {
+				return NFTTransferLog{}, false
+			}
+			transfer.Items = []NFTItem{{
+				TokenID: new(big.Int).SetBytes(log.Data[:32]),
+				Amount:  new(big.Int).SetBytes(log.Data[32:]),
+			}}
+		} else {
+			items, err := decodeERC1155Batch(log.Data)
+			if err != nil {
+				return NFTTransferLog{}, false
+			}
+			transfer.Items = items
+		}
+		return transfer, true
+	}
+	return NFTTransferLog{}, false
+}
+
+/*
+ExtractNFTTransfers function: decodes the ERC-721 and ERC-1155 transfers of a
+receipt, returned separately per standard
+*/
+func ExtractNFTTransfers(receipt *types.Receipt) (erc721 []NFTTransferLog, erc1155 []NFTTransferLog) {
+	for _, log := range receipt.Logs {
+		if transfer, ok := nftTransferOf(log); ok {
+			if transfer.Standard == STANDARD_ERC721 {
+				erc721 = append(erc721, transfer)
+			} else {
+				erc1155 = append(erc1155, transfer)
+			}
+		}
+	}
+	return erc721, erc1155
+}
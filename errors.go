@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+// *********************** error model *****************************************
+
+// errorKind classifies every failure a handler can report
+type errorKind int
+
+const (
+	ErrInternal errorKind = iota
+	ErrNotFound
+	ErrInvalidInput
+	ErrUpstream
+	ErrTimeout
+	ErrForbidden
+)
+
+// status returns the http status code rendered for the kind
+func (k errorKind) status() int {
+	switch k {
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrInvalidInput:
+		return http.StatusBadRequest
+	case ErrUpstream:
+		return http.StatusBadGateway
+	case ErrTimeout:
+		return http.StatusGatewayTimeout
+	case ErrForbidden:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// explorerError is the error every handler and loader returns
+type explorerError struct {
+	Kind    errorKind
+	Message string // shown to the user
+	Err     error  // underlying cause, optional
+}
+
+func (e *explorerError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *explorerError) Unwrap() error {
+	return e.Err
+}
+
+func notFound(msg string, err error) error {
+	return &explorerError{Kind: ErrNotFound, Message: msg, Err: err}
+}
+
+func invalidInput(msg string) error {
+	return &explorerError{Kind: ErrInvalidInput, Message: msg}
+}
+
+/*
+nodeError function: classifies an error returned by a node call; missing
+objects become not found, deadlines become timeouts, the rest upstream failures
+*/
+func nodeError(msg string, err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ethereum.NotFound):
+		return &explorerError{Kind: ErrNotFound, Message: msg, Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &explorerError{Kind: ErrTimeout, Message: msg, Err: err}
+	default:
+		return &explorerError{Kind: ErrUpstream, Message: msg, Err: err}
+	}
+}
+
+/*
+asExplorerError function: returns err as an explorerError, wrapping foreign
+errors as internal failures
+*/
+func asExplorerError(err error) *explorerError {
+	var e *explorerError
+	if errors.As(err, &e) {
+		return e
+	}
+	return &explorerError{Kind: ErrInternal, Message: "Unexpected failure", Err: err}
+}
+
+// *********************** error rendering *************************************
+
+// appHandler is a handler that reports failures instead of rendering them
+type appHandler func(w http.ResponseWriter, r *http.Request) error
+
+func (h appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h(w, r); err != nil {
+		renderError(w, r, err)
+	}
+}
+
+/*
+wantsJSON function: api requests and clients asking for json get the error
+envelope instead of the error page
+*/
+func wantsJSON(r *http.Request) bool {
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/n/") {
+		// the api of a network, /n/<name>/api/v1/...
+		if i := strings.Index(path[len("/n/"):], "/"); i >= 0 {
+			path = path[len("/n/")+i:]
+		}
+	}
+	return strings.HasPrefix(path, API_PREFIX+"/") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+/*
+renderError function: renders err into 404.html or the api error envelope with
+the status code of its kind
+*/
+func renderError(w http.ResponseWriter, r *http.Request, err error) {
+	e := asExplorerError(err)
+	status := e.Kind.status()
+	if status >= http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+
+	if wantsJSON(r) {
+		body := apiError{Status: status, Message: e.Message}
+		if e.Err != nil {
+			body.Detail = e.Err.Error()
+		}
+		writeJSON(w, status, apiErrorEnvelope{Error: body})
+		return
+	}
+
+	tmpl, tmplErr := pageTemplate(r.Context(), "404.html")
+	if tmplErr != nil {
+		log.Println("error page:", tmplErr)
+		http.Error(w, e.Message, status)
+		return
+	}
+	w.WriteHeader(status)
+	tmpl.Execute(w, txLogs{
+		Status:   uint64(status),
+		Log:      e.Message,
+		ErrorMsg: e.Err,
+		Host:     "homepage",
+	})
+}
+
+/*
+recoverPanics middleware: turns a panic in any handler into an internal error
+response so one bad request never takes the explorer down
+*/
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("panic serving %s: %v\n%s", r.URL.Path, p, debug.Stack())
+				renderError(w, r, fmt.Errorf("%v", p))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+checkOrigin middleware: refuses a request that changes state when the page that
+sent it is on another site, so a page elsewhere cannot drive the dev chain,
+send or faucet from the browser of a visitor. Requests naming no origin, like
+those of curl and scripts, pass
+*/
+func checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !sameOrigin(r) {
+				renderError(w, r, &explorerError{Kind: ErrForbidden, Message: "Requests from other sites may not change anything"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether the Origin, or the Referer without one, of r names the host r was sent to
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		origin  string
+		referer string
+		want    int
+	}{
+		{name: "page read from another site", method: http.MethodGet, origin: "http://evil.example", want: http.StatusOK},
+		{name: "write without origin", method: http.MethodPost, want: http.StatusOK},
+		{name: "write from the explorer", method: http.MethodPost, origin: "http://127.0.0.1:5051", want: http.StatusOK},
+		{name: "write referred by the explorer", method: http.MethodPost, referer: "http://127.0.0.1:5051/send", want: http.StatusOK},
+		{name: "write from another site", method: http.MethodPost, origin: "http://evil.example", want: http.StatusForbidden},
+		{name: "write from another port", method: http.MethodPost, origin: "http://127.0.0.1:3000", want: http.StatusForbidden},
+		{name: "write referred by another site", method: http.MethodPost, referer: "http://evil.example/page", want: http.StatusForbidden},
+		{name: "write from an opaque origin", method: http.MethodPost, origin: "null", want: http.StatusForbidden},
+		{name: "abi upload from another site", method: http.MethodPut, origin: "http://evil.example", want: http.StatusForbidden},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://127.0.0.1:5051"+API_PREFIX+"/faucet", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			rec := httptest.NewRecorder()
+			checkOrigin(ok).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,616 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	bolt "go.etcd.io/bbolt"
+)
+
+// *********************** index ***********************************************
+
+const (
+	INDEX_PATH          = "explorer.db"   // on-disk location of the block index
+	INDEX_POLL_INTERVAL = 2 * time.Second // how often the indexer looks for a new head
+	INDEX_VERSION       = 6               // bump to rebuild existing indexes after a layout change
+)
+
+var (
+	bucketMeta           = []byte("meta")           // head height, genesis hash
+	bucketBlocks         = []byte("blocks")         // number -> indexedBlock
+	bucketBlockHashes    = []byte("blockHashes")    // hash -> number
+	bucketTxs            = []byte("txs")            // tx hash -> indexedTx
+	bucketLogs           = []byte("logs")           // contract | number | log index -> tx hash
+	bucketAddressTxs     = []byte("addressTxs")     // address | number | tx index | seq -> addressActivity
+	bucketTokensIn       = []byte("tokensIn")       // receiver | token -> number of the first Transfer
+	bucketTokens         = []byte("tokens")         // token -> tokenInfo
+	bucketTokenTransfers = []byte("tokenTransfers") // token | number | log index -> standard | tx hash
+	bucketTokenBalances  = []byte("tokenBalances")  // token | holder -> signed decimal balance
+
+	indexBuckets = [][]byte{bucketMeta, bucketBlocks, bucketBlockHashes, bucketTxs, bucketLogs, bucketAddressTxs, bucketTokensIn, bucketTokens, bucketTokenTransfers, bucketTokenBalances}
+
+	keyHead    = []byte("head")
+	keyGenesis = []byte("genesis")
+	keyVersion = []byte("version")
+)
+
+var errNotIndexed = errors.New("not indexed")
+
+// for a block as kept in the index
+type indexedBlock struct {
+	Header       *types.Header   `json:"header"`
+	Extension    headerExtension `json:"extension"`
+	Hash         common.Hash     `json:"hash"` // as reported by the node
+	Size         uint64          `json:"size"`
+	Transactions []common.Hash   `json:"transactions"`
+	Uncles       []common.Hash   `json:"uncles"`
+	Withdrawals  []withdrawal    `json:"withdrawals,omitempty"`
+	Unsupported  []unsupportedTx `json:"unsupported,omitempty"` // not indexed, see decodeBlock
+}
+
+// for a transaction as kept in the index, together with its receipt and logs
+type indexedTx struct {
+	Tx       *types.Transaction `json:"tx"`
+	From     common.Address     `json:"from"`
+	Receipt  *types.Receipt     `json:"receipt"`
+	Internal []internalTransfer `json:"internal,omitempty"`
+}
+
+// hasHash reports whether hash names the block, by the node's or our own hashing
+func (b *indexedBlock) hasHash(hash common.Hash) bool {
+	return b.Hash == hash || deriveBlockHash(b.Header, b.Extension) == hash
+}
+
+// blockIndex follows the chain head of one node and mirrors it into bolt
+type blockIndex struct {
+	db      *bolt.DB
+	host    string
+	client  *ethclient.Client
+	rpc     *EthRPC
+	tracing bool // cleared once the node turns out not to support debug_traceTransaction
+}
+
+/*
+openIndex function: opens (or creates) the index at path for the node at host
+*/
+func openIndex(path string, host string) (*blockIndex, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range indexBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	indexClient, err := dialNode(host)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &blockIndex{db: db, host: host, client: indexClient, rpc: newClient(host), tracing: true}, nil
+}
+
+/*
+activeIndex function: returns the index of the network of ctx, nil when it has
+none
+*/
+func activeIndex(ctx context.Context) *blockIndex {
+	return networkOf(ctx).index
+}
+
+func encodeNumber(n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
+
+func pairKey(first, second common.Address) []byte {
+	return append(append(make([]byte, 0, 2*common.AddressLength), first.Bytes()...), second.Bytes()...)
+}
+
+func logKey(contract common.Address, number uint64, logIndex uint) []byte {
+	key := make([]byte, 0, common.AddressLength+12)
+	key = append(key, contract.Bytes()...)
+	key = append(key, encodeNumber(number)...)
+	index := make([]byte, 4)
+	binary.BigEndian.PutUint32(index, uint32(logIndex))
+	return append(key, index...)
+}
+
+// *********************** indexer *********************************************
+
+/*
+height function: returns the number of the last indexed block; ok is false on
+an empty index
+*/
+func (idx *blockIndex) height() (height uint64, ok bool) {
+	idx.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(bucketMeta).Get(keyHead); value != nil {
+			height, ok = binary.BigEndian.Uint64(value), true
+		}
+		return nil
+	})
+	return height, ok
+}
+
+/*
+run function: follows the chain head forever, resuming from the last indexed
+height
+*/
+func (idx *blockIndex) run() {
+	if err := idx.checkCompatibility(); err != nil {
+		log.Println("index: disabled,", err)
+		return
+	}
+	if height, ok := idx.height(); ok {
+		log.Printf("index: resuming after block %d", height)
+	}
+	for {
+		if err := idx.sync(); err != nil {
+			log.Println("index:", err)
+		}
+		time.Sleep(INDEX_POLL_INTERVAL)
+	}
+}
+
+/*
+checkCompatibility function: wipes the index when it was built for another
+chain, e.g. after ganache was restarted, or by an older explorer version
+*/
+func (idx *blockIndex) checkCompatibility() error {
+	genesis, err := readBlock(context.Background(), idx.rpc, big.NewInt(0))
+	if err != nil {
+		return fmt.Errorf("genesis lookup failed: %w", err)
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		storedGenesis, storedVersion := meta.Get(keyGenesis), meta.Get(keyVersion)
+		chainChanged := storedGenesis != nil && common.BytesToHash(storedGenesis) != genesis.Hash()
+		layoutChanged := meta.Get(keyHead) != nil && (storedVersion == nil || binary.BigEndian.Uint64(storedVersion) != INDEX_VERSION)
+		if chainChanged || layoutChanged {
+			log.Println("index: chain or index layout changed, rebuilding")
+			for _, name := range indexBuckets {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+				if _, err := tx.CreateBucket(name); err != nil {
+					return err
+				}
+			}
+			meta = tx.Bucket(bucketMeta)
+		}
+		if err := meta.Put(keyVersion, encodeNumber(INDEX_VERSION)); err != nil {
+			return err
+		}
+		return meta.Put(keyGenesis, genesis.Hash().Bytes())
+	})
+}
+
+/*
+sync function: indexes every block between the last indexed height and the
+current head, rewinding first when the node dropped or replaced blocks
+(evm_revert, reorg)
+*/
+func (idx *blockIndex) sync() error {
+	head, err := idx.client.BlockNumber(context.Background())
+	if err != nil {
+		return err
+	}
+	next := uint64(0)
+	if height, ok := idx.height(); ok {
+		next = height + 1
+		if height >= head {
+			// no new blocks, but the head may be another block than the one
+			// indexed at its height (evm_revert, then as many new blocks)
+			replaced, err := idx.replaced(head)
+			if err != nil {
+				return err
+			}
+			if height > head || replaced {
+				if err := idx.rewind(head); err != nil {
+					return err
+				}
+				next = head
+			}
+		}
+	}
+	for n := next; n <= head; n++ {
+		block, err := readBlock(context.Background(), idx.rpc, new(big.Int).SetUint64(n))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			if parent, err := idx.block(n - 1); err == nil && !parent.hasHash(block.ParentHash()) {
+				// the node replaced our last blocks; drop them down to the block
+				// both chains share and index the node's chain from there
+				ancestor, err := idx.commonAncestor(n - 1)
+				if err != nil {
+					return err
+				}
+				if err := idx.rewind(ancestor + 1); err != nil {
+					return err
+				}
+				n = ancestor
+				continue
+			}
+		}
+		if err := idx.indexBlock(block); err != nil {
+			return fmt.Errorf("block %d: %w", n, err)
+		}
+	}
+	return nil
+}
+
+/*
+replaced function: whether the node has another block at number than the
+index; false when number is not indexed
+*/
+func (idx *blockIndex) replaced(number uint64) (bool, error) {
+	stored, err := idx.block(number)
+	if err != nil {
+		return false, nil
+	}
+	block, err := readBlock(context.Background(), idx.rpc, new(big.Int).SetUint64(number))
+	if err != nil {
+		return false, err
+	}
+	return !stored.hasHash(block.Hash()), nil
+}
+
+/*
+commonAncestor function: the highest block from number down that the index and
+the node agree on; 0 (genesis) when they share no other. Sync checked the
+genesis on open
+*/
+func (idx *blockIndex) commonAncestor(number uint64) (uint64, error) {
+	for ; number > 0; number-- {
+		stored, err := idx.block(number)
+		if err != nil {
+			continue // not indexed, nothing to compare
+		}
+		block, err := readBlock(context.Background(), idx.rpc, new(big.Int).SetUint64(number))
+		if err != nil {
+			return 0, err
+		}
+		if stored.hasHash(block.Hash()) {
+			break
+		}
+	}
+	return number, nil
+}
+
+/*
+indexBlock function: stores one block with its transactions, receipts and logs
+*/
+func (idx *blockIndex) indexBlock(block *chainBlock) error {
+	number := block.NumberU64()
+	stored := indexedBlock{
+		Header:      block.Header(),
+		Extension:   block.Extension,
+		Hash:        block.Hash(),
+		Size:        block.size,
+		Uncles:      block.UncleHashes,
+		Withdrawals: block.Withdrawals,
+		Unsupported: block.Unsupported,
+	}
+	var txs []indexedTx
+	hashes := make([]common.Hash, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		hashes[i] = tx.Hash()
+	}
+	receipts, err := idx.rpc.TransactionReceipts(context.Background(), hashes)
+	if err != nil {
+		return err
+	}
+	for i, tx := range block.Transactions() {
+		receipt := receipts[i]
+		from, _ := types.LatestSignerForChainID(tx.ChainId()).Sender(tx)
+		stored.Transactions = append(stored.Transactions, tx.Hash())
+		txs = append(txs, indexedTx{Tx: tx, From: from, Receipt: receipt, Internal: idx.internalTransfers(tx)})
+	}
+
+	return idx.db.Update(func(btx *bolt.Tx) error {
+		encoded, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		if err := btx.Bucket(bucketBlocks).Put(encodeNumber(number), encoded); err != nil {
+			return err
+		}
+		hashes := btx.Bucket(bucketBlockHashes)
+		for _, hash := range []common.Hash{stored.Hash, deriveBlockHash(stored.Header, stored.Extension)} {
+			if err := hashes.Put(hash.Bytes(), encodeNumber(number)); err != nil {
+				return err
+			}
+		}
+		for _, itx := range txs {
+			encoded, err := json.Marshal(itx)
+			if err != nil {
+				return err
+			}
+			if err := btx.Bucket(bucketTxs).Put(itx.Tx.Hash().Bytes(), encoded); err != nil {
+				return err
+			}
+			for _, l := range itx.Receipt.Logs {
+				if err := btx.Bucket(bucketLogs).Put(logKey(l.Address, number, l.Index), itx.Tx.Hash().Bytes()); err != nil {
+					return err
+				}
+				if IsTokenTransfer(l) {
+					key := pairKey(common.BytesToAddress(l.Topics[2].Bytes()), l.Address)
+					if btx.Bucket(bucketTokensIn).Get(key) == nil {
+						if err := btx.Bucket(bucketTokensIn).Put(key, encodeNumber(number)); err != nil {
+							return err
+						}
+					}
+				}
+				if err := indexTokenMovement(btx, l, 1); err != nil {
+					return err
+				}
+			}
+			for _, record := range activityOf(&itx, block.Time()) {
+				encoded, err := json.Marshal(record.Activity)
+				if err != nil {
+					return err
+				}
+				if err := btx.Bucket(bucketAddressTxs).Put(activityKey(record), encoded); err != nil {
+					return err
+				}
+			}
+		}
+		return btx.Bucket(bucketMeta).Put(keyHead, encodeNumber(number))
+	})
+}
+
+/*
+internalTransfers function: traces contract calls for the ETH they move
+internally; stays silent when the node cannot trace
+*/
+func (idx *blockIndex) internalTransfers(tx *types.Transaction) []internalTransfer {
+	if !idx.tracing || len(tx.Data()) == 0 {
+		return nil
+	}
+	frame, err := traceCalls(context.Background(), idx.rpc, tx.Hash())
+	if err != nil {
+		if isTracingUnsupported(err) {
+			log.Println("index: node does not support call tracing, internal transfers will not be indexed:", err)
+			idx.tracing = false
+		}
+		return nil
+	}
+	return frame.internalTransfers()
+}
+
+/*
+rewind function: removes every block from number upwards
+*/
+func (idx *blockIndex) rewind(from uint64) error {
+	log.Printf("index: rewinding to block %d", from)
+	return idx.db.Update(func(btx *bolt.Tx) error {
+		blocks := btx.Bucket(bucketBlocks)
+		cursor := blocks.Cursor()
+		for key, value := cursor.Seek(encodeNumber(from)); key != nil; key, value = cursor.Next() {
+			var stored indexedBlock
+			if err := json.Unmarshal(value, &stored); err != nil {
+				return err
+			}
+			btx.Bucket(bucketBlockHashes).Delete(stored.Hash.Bytes())
+			btx.Bucket(bucketBlockHashes).Delete(deriveBlockHash(stored.Header, stored.Extension).Bytes())
+			for _, hash := range stored.Transactions {
+				if encoded := btx.Bucket(bucketTxs).Get(hash.Bytes()); encoded != nil {
+					var itx indexedTx
+					if err := json.Unmarshal(encoded, &itx); err == nil {
+						for _, l := range itx.Receipt.Logs {
+							btx.Bucket(bucketLogs).Delete(logKey(l.Address, l.BlockNumber, l.Index))
+							if IsTokenTransfer(l) {
+								// only forget the token when every Transfer to the receiver is being rewound
+								key := pairKey(common.BytesToAddress(l.Topics[2].Bytes()), l.Address)
+								if first := btx.Bucket(bucketTokensIn).Get(key); first != nil && binary.BigEndian.Uint64(first) >= from {
+									btx.Bucket(bucketTokensIn).Delete(key)
+								}
+							}
+							if err := indexTokenMovement(btx, l, -1); err != nil {
+								return err
+							}
+						}
+						for _, record := range activityOf(&itx, stored.Header.Time) {
+							btx.Bucket(bucketAddressTxs).Delete(activityKey(record))
+						}
+					}
+				}
+				btx.Bucket(bucketTxs).Delete(hash.Bytes())
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		// rewound contracts may be redeployed at the same address with other metadata
+		tokens.forget(idx.host)
+		if err := btx.DeleteBucket(bucketTokens); err != nil {
+			return err
+		}
+		if _, err := btx.CreateBucket(bucketTokens); err != nil {
+			return err
+		}
+
+		meta := btx.Bucket(bucketMeta)
+		if from == 0 {
+			return meta.Delete(keyHead)
+		}
+		return meta.Put(keyHead, encodeNumber(from-1))
+	})
+}
+
+// *********************** index reads *****************************************
+
+func (idx *blockIndex) block(number uint64) (*indexedBlock, error) {
+	var stored *indexedBlock
+	err := idx.db.View(func(btx *bolt.Tx) error {
+		value := btx.Bucket(bucketBlocks).Get(encodeNumber(number))
+		if value == nil {
+			return errNotIndexed
+		}
+		stored = new(indexedBlock)
+		return json.Unmarshal(value, stored)
+	})
+	return stored, err
+}
+
+func (idx *blockIndex) blockNumberByHash(hash common.Hash) (uint64, error) {
+	var number uint64
+	err := idx.db.View(func(btx *bolt.Tx) error {
+		value := btx.Bucket(bucketBlockHashes).Get(hash.Bytes())
+		if value == nil {
+			return errNotIndexed
+		}
+		number = binary.BigEndian.Uint64(value)
+		return nil
+	})
+	return number, err
+}
+
+func (idx *blockIndex) tx(hash common.Hash) (*indexedTx, error) {
+	var itx *indexedTx
+	err := idx.db.View(func(btx *bolt.Tx) error {
+		value := btx.Bucket(bucketTxs).Get(hash.Bytes())
+		if value == nil {
+			return errNotIndexed
+		}
+		itx = new(indexedTx)
+		return json.Unmarshal(value, itx)
+	})
+	return itx, err
+}
+
+/*
+tokensReceived function: lists every token contract that ever sent a Transfer
+to address
+*/
+func (idx *blockIndex) tokensReceived(address common.Address) ([]common.Address, error) {
+	var tokens []common.Address
+	err := idx.db.View(func(btx *bolt.Tx) error {
+		prefix := address.Bytes()
+		cursor := btx.Bucket(bucketTokensIn).Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && hasPrefix(key, prefix); key, _ = cursor.Next() {
+			tokens = append(tokens, common.BytesToAddress(key[common.AddressLength:]))
+		}
+		return nil
+	})
+	return tokens, err
+}
+
+/*
+fullBlock function: rebuilds the chainBlock of an indexed block
+*/
+func (idx *blockIndex) fullBlock(stored *indexedBlock) (*chainBlock, error) {
+	txs := make([]*types.Transaction, 0, len(stored.Transactions))
+	for _, hash := range stored.Transactions {
+		itx, err := idx.tx(hash)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, itx.Tx)
+	}
+	return &chainBlock{
+		Block:       types.NewBlockWithHeader(stored.Header).WithBody(txs, nil),
+		hash:        stored.Hash,
+		size:        stored.Size,
+		Extension:   stored.Extension,
+		Withdrawals: stored.Withdrawals,
+		UncleHashes: stored.Uncles,
+		Unsupported: stored.Unsupported,
+	}, nil
+}
+
+// *********************** chain reads *****************************************
+// the handlers read through these: the index answers when it has the data, the
+// node otherwise
+
+func fetchBlockByNumber(ctx context.Context, number *big.Int) (*chainBlock, error) {
+	if idx := activeIndex(ctx); idx != nil && number != nil && number.IsUint64() {
+		if stored, err := idx.block(number.Uint64()); err == nil {
+			if block, err := idx.fullBlock(stored); err == nil {
+				return block, nil
+			}
+		}
+	}
+	return readBlock(ctx, networkOf(ctx).rpc, number)
+}
+
+func fetchBlockByHash(ctx context.Context, hash common.Hash) (*chainBlock, error) {
+	if idx := activeIndex(ctx); idx != nil {
+		if number, err := idx.blockNumberByHash(hash); err == nil {
+			if block, err := fetchBlockByNumber(ctx, new(big.Int).SetUint64(number)); err == nil {
+				return block, nil
+			}
+		}
+	}
+	return readBlock(ctx, networkOf(ctx).rpc, hash)
+}
+
+func fetchTransaction(ctx context.Context, hash common.Hash) (*types.Transaction, error) {
+	if idx := activeIndex(ctx); idx != nil {
+		if itx, err := idx.tx(hash); err == nil {
+			return itx.Tx, nil
+		}
+	}
+	tx, _, err := nodeOf(ctx).TransactionByHash(ctx, hash)
+	return tx, err
+}
+
+func fetchReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	if idx := activeIndex(ctx); idx != nil {
+		if itx, err := idx.tx(hash); err == nil {
+			return itx.Receipt, nil
+		}
+	}
+	return nodeOf(ctx).TransactionReceipt(ctx, hash)
+}
+
+/*
+fetchReceipts function: the receipts of txs, from the index when it has them
+and otherwise from the node in one batched round trip
+*/
+func fetchReceipts(ctx context.Context, txs types.Transactions) ([]*types.Receipt, error) {
+	receipts := make([]*types.Receipt, len(txs))
+	var missing []common.Hash
+	var positions []int
+	idx := activeIndex(ctx)
+	for i, tx := range txs {
+		if idx != nil {
+			if itx, err := idx.tx(tx.Hash()); err == nil {
+				receipts[i] = itx.Receipt
+				continue
+			}
+		}
+		missing = append(missing, tx.Hash())
+		positions = append(positions, i)
+	}
+	if len(missing) == 0 {
+		return receipts, nil
+	}
+	fetched, err := networkOf(ctx).rpc.TransactionReceipts(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for i, receipt := range fetched {
+		receipts[positions[i]] = receipt
+	}
+	return receipts, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+chainOf function: the blocks of a chain without transactions, the first
+shared of them from base and the others made distinct by fork in extraData
+*/
+func chainOf(base []*types.Header, shared, length int, fork string) []*types.Header {
+	chain := append([]*types.Header(nil), base[:shared]...)
+	for n := shared; n < length; n++ {
+		header := &types.Header{
+			Number:      big.NewInt(int64(n)),
+			Difficulty:  big.NewInt(1),
+			GasLimit:    30000000,
+			Time:        uint64(1000 + n),
+			Extra:       []byte(fork),
+			UncleHash:   types.EmptyUncleHash,
+			TxHash:      types.EmptyRootHash,
+			ReceiptHash: types.EmptyRootHash,
+		}
+		if n > 0 {
+			header.ParentHash = chain[n-1].Hash()
+		}
+		chain = append(chain, header)
+	}
+	return chain
+}
+
+// chainNode serves eth_blockNumber and eth_getBlockByNumber from the chain it is given
+type chainNode struct {
+	mu    sync.Mutex
+	chain []*types.Header
+}
+
+func (node *chainNode) answer(req ethRequest) ethResponse {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	resp := ethResponse{ID: req.ID, JSONRPC: "2.0"}
+	switch req.Method {
+	case "eth_blockNumber":
+		resp.Result, _ = json.Marshal(hexutil.Uint64(len(node.chain) - 1))
+	case "eth_getBlockByNumber":
+		resp.Result = json.RawMessage("null")
+		number, err := hexutil.DecodeUint64(req.Params[0].(string))
+		if err == nil && number < uint64(len(node.chain)) {
+			block := map[string]interface{}{}
+			encoded, _ := json.Marshal(node.chain[number])
+			json.Unmarshal(encoded, &block)
+			block["transactions"], block["uncles"] = []interface{}{}, []interface{}{}
+			resp.Result, _ = json.Marshal(block)
+		}
+	default:
+		resp.Error = &EthError{Code: -32601, Message: "method not found"}
+	}
+	return resp
+}
+
+func (node *chainNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if raw[0] != '[' {
+		var req ethRequest
+		json.Unmarshal(raw, &req)
+		json.NewEncoder(w).Encode(node.answer(req))
+		return
+	}
+	var requests []ethRequest
+	json.Unmarshal(raw, &requests)
+	responses := make([]ethResponse, len(requests))
+	for i, req := range requests {
+		responses[i] = node.answer(req)
+	}
+	json.NewEncoder(w).Encode(responses)
+}
+
+func TestSyncFollowsFork(t *testing.T) {
+	indexed := chainOf(nil, 0, 6, "a") // blocks 0 to 5
+	tests := []struct {
+		name   string
+		shared int // blocks both chains have
+		length int // of the chain the node switched to
+	}{
+		{"longer branch", 3, 7},
+		{"branch of the same height", 3, 6},
+		{"shorter branch", 3, 5},
+		{"replaced head", 5, 6},
+		{"fork above genesis", 1, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &chainNode{chain: indexed}
+			server := httptest.NewServer(node)
+			defer server.Close()
+			idx, err := openIndex(filepath.Join(t.TempDir(), "index.db"), server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer releaseNode(server.URL)
+			defer idx.db.Close()
+			idx.tracing = false
+
+			if err := idx.sync(); err != nil {
+				t.Fatal(err)
+			}
+			fork := chainOf(indexed, tt.shared, tt.length, "b")
+			node.mu.Lock()
+			node.chain = fork
+			node.mu.Unlock()
+			if err := idx.sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			if height, _ := idx.height(); height != uint64(len(fork)-1) {
+				t.Errorf("height %d, want %d", height, len(fork)-1)
+			}
+			for n, header := range fork {
+				stored, err := idx.block(uint64(n))
+				if err != nil {
+					t.Fatalf("block %d: %v", n, err)
+				}
+				if stored.Hash != header.Hash() {
+					t.Errorf("block %d is %s, want %s of the node", n, stored.Hash.Hex(), header.Hash().Hex())
+				}
+			}
+			if _, err := idx.block(uint64(len(fork))); err == nil {
+				t.Errorf("block %d above the head of the node still indexed", len(fork))
+			}
+			idx.db.View(func(btx *bolt.Tx) error {
+				for _, header := range indexed[tt.shared:] {
+					if btx.Bucket(bucketBlockHashes).Get(header.Hash().Bytes()) != nil {
+						t.Errorf("hash %s of the dropped block %d still indexed", header.Hash().Hex(), header.Number)
+					}
+				}
+				return nil
+			})
+		})
+	}
+}
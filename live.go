@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// *********************** live feed **************************************
+
+const (
+	LIVE_POLL_INTERVAL     = 2 * time.Second  // how often an http node is polled for new blocks
+	LIVE_BACKLOG           = 100              // default of the recent events replayed to a reconnecting browser
+	LIVE_MAX_CATCH_UP      = 10               // blocks pushed at most after falling behind
+	LIVE_STREAM_SPAN       = 10 * time.Second // an event stream ends before requestTimeout, the browser resumes it
+	LIVE_RETRY_MS          = 500              // how soon the browser reconnects to an ended stream
+	LIVE_SUBSCRIBER_BUFFER = 64
+)
+
+// event types pushed to the browser
+const (
+	LIVE_EVENT_BLOCK   = "block"
+	LIVE_EVENT_PENDING = "pending"
+)
+
+// liveEvent is one server-sent event, numbered so a browser can resume after it
+type liveEvent struct {
+	ID   uint64
+	Type string
+	Data []byte
+}
+
+// pendingTx is the payload of a pending event
+type pendingTx struct {
+	Hash  string  `json:"hash"`
+	From  string  `json:"from,omitempty"`
+	To    string  `json:"to,omitempty"`
+	Value string  `json:"value,omitempty"` // in ether
+	Nonce *uint64 `json:"nonce,omitempty"`
+}
+
+// liveFeed fans the events of the node out to every connected browser
+type liveFeed struct {
+	mu          sync.Mutex
+	lastID      uint64
+	recent      []liveEvent
+	subscribers map[chan liveEvent]struct{}
+}
+
+func newLiveFeed() *liveFeed {
+	return &liveFeed{subscribers: make(map[chan liveEvent]struct{})}
+}
+
+/*
+publish function: numbers an event, keeps it for resuming browsers and hands it
+to every subscriber; a subscriber too slow to take it misses it
+*/
+func (feed *liveFeed) publish(eventType string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("live:", err)
+		return
+	}
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	feed.lastID++
+	event := liveEvent{ID: feed.lastID, Type: eventType, Data: data}
+	feed.recent = append(feed.recent, event)
+	if backlog := config.Caches.LiveBacklog; len(feed.recent) > backlog {
+		feed.recent = feed.recent[len(feed.recent)-backlog:]
+	}
+	for subscriber := range feed.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+/*
+subscribe function: registers a subscriber and returns the kept events after
+lastID, which the subscriber missed while reconnecting
+*/
+func (feed *liveFeed) subscribe(lastID uint64) (chan liveEvent, []liveEvent) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	subscriber := make(chan liveEvent, LIVE_SUBSCRIBER_BUFFER)
+	feed.subscribers[subscriber] = struct{}{}
+	var missed []liveEvent
+	for _, event := range feed.recent {
+		if event.ID > lastID {
+			missed = append(missed, event)
+		}
+	}
+	return subscriber, missed
+}
+
+// latest is the id of the newest event, a page rendered now has seen up to it
+func (feed *liveFeed) latest() uint64 {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	return feed.lastID
+}
+
+func (feed *liveFeed) unsubscribe(subscriber chan liveEvent) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	delete(feed.subscribers, subscriber)
+}
+
+/*
+run function: follows the node of net for good, through a newHeads and
+newPendingTransactions subscription on websocket and ipc nodes and by polling
+eth_blockNumber and a pending transaction filter on http nodes
+*/
+func (feed *liveFeed) run(net *network) {
+	ctx := withNetworkContext(context.Background(), net, "")
+	var head *big.Int
+	for {
+		_, err := net.connect()
+		if err == nil {
+			if isWebsocketEndpoint(net.URL) || isIPCEndpoint(net.URL) {
+				head, err = feed.subscribeNode(ctx, net.URL, head)
+			} else {
+				head, err = feed.pollNode(ctx, net.rpc, head)
+			}
+		}
+		log.Println("live:", net.Name+":", err)
+		time.Sleep(LIVE_POLL_INTERVAL)
+	}
+}
+
+/*
+subscribeNode function: pushes the heads and pending transactions a websocket
+or ipc node notifies until the subscription breaks; head is the last pushed
+block
+*/
+func (feed *liveFeed) subscribeNode(ctx context.Context, host string, head *big.Int) (*big.Int, error) {
+	url := host
+	if isIPCEndpoint(host) {
+		url = ipcPath(host)
+	}
+	rpcClient, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return head, fmt.Errorf("couldn't connect to %s: %w", host, err)
+	}
+	defer rpcClient.Close()
+
+	// the page shows the blocks up to now, the first notified one is news
+	if head == nil {
+		var number hexutil.Big
+		if err := rpcClient.CallContext(ctx, &number, "eth_blockNumber"); err != nil {
+			return head, fmt.Errorf("eth_blockNumber failed: %w", err)
+		}
+		head = number.ToInt()
+	}
+
+	heads := make(chan *types.Header, LIVE_SUBSCRIBER_BUFFER)
+	headSub, err := rpcClient.EthSubscribe(ctx, heads, "newHeads")
+	if err != nil {
+		return head, fmt.Errorf("newHeads subscription failed: %w", err)
+	}
+	defer headSub.Unsubscribe()
+
+	// pending transactions are optional, not every node offers them
+	hashes := make(chan common.Hash, LIVE_SUBSCRIBER_BUFFER)
+	var pendingErr <-chan error
+	if pendingSub, err := rpcClient.EthSubscribe(ctx, hashes, "newPendingTransactions"); err != nil {
+		log.Println("live: no pending transactions from", host+",", err)
+	} else {
+		defer pendingSub.Unsubscribe()
+		pendingErr = pendingSub.Err()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return head, ctx.Err()
+		case err := <-headSub.Err():
+			return head, fmt.Errorf("newHeads subscription ended: %w", err)
+		case err := <-pendingErr:
+			log.Println("live: newPendingTransactions subscription ended,", err)
+			pendingErr = nil
+		case header := <-heads:
+			head = feed.publishBlocks(ctx, head, header.Number)
+		case hash := <-hashes:
+			feed.publishPending(ctx, hash)
+		}
+	}
+}
+
+/*
+pollNode function: pushes the blocks mined since the last poll of
+eth_blockNumber, and the transactions of an eth_newPendingTransactionFilter,
+until the node stops answering; head is the last pushed block
+*/
+func (feed *liveFeed) pollNode(ctx context.Context, node *EthRPC, head *big.Int) (*big.Int, error) {
+	var filterID string
+	ticker := time.NewTicker(LIVE_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		// a filter expires when it is not polled, or with a restarted node
+		if filterID == "" {
+			if err := node.call(ctx, "eth_newPendingTransactionFilter", &filterID); err != nil {
+				filterID = ""
+			}
+		}
+		if filterID != "" {
+			var hashes []common.Hash
+			if err := node.call(ctx, "eth_getFilterChanges", &hashes, filterID); err != nil {
+				filterID = ""
+			}
+			for _, hash := range hashes {
+				feed.publishPending(ctx, hash)
+			}
+		}
+
+		// after the pending ones, a mined transaction reaches the page last
+		var number hexutil.Big
+		if err := node.call(ctx, "eth_blockNumber", &number); err != nil {
+			return head, fmt.Errorf("eth_blockNumber failed: %w", err)
+		}
+		head = feed.publishBlocks(ctx, head, number.ToInt())
+
+		select {
+		case <-ctx.Done():
+			return head, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+publishBlocks function: pushes the blocks after head up to number, at most
+LIVE_MAX_CATCH_UP of them, and returns the new head; the first head seen is
+only remembered, the page already shows it
+*/
+func (feed *liveFeed) publishBlocks(ctx context.Context, head, number *big.Int) *big.Int {
+	if head == nil || number.Cmp(head) < 0 {
+		return new(big.Int).Set(number) // first head, or a chain reset under us
+	}
+	from := new(big.Int).Add(head, big.NewInt(1))
+	if behind := new(big.Int).Sub(number, from); behind.Cmp(big.NewInt(LIVE_MAX_CATCH_UP)) >= 0 {
+		from.Sub(number, big.NewInt(LIVE_MAX_CATCH_UP-1))
+	}
+	for n := from; n.Cmp(number) <= 0; n.Add(n, big.NewInt(1)) {
+		blockData, err := blockPage(ctx, n)
+		if err != nil {
+			log.Println("live: block", n, err)
+			return new(big.Int).Sub(n, big.NewInt(1))
+		}
+		feed.publish(LIVE_EVENT_BLOCK, blockData)
+	}
+	return new(big.Int).Set(number)
+}
+
+/*
+publishPending function: pushes a pending transaction, with its sender,
+recipient and value when the node still has it
+*/
+func (feed *liveFeed) publishPending(ctx context.Context, hash common.Hash) {
+	pending := pendingTx{Hash: hash.Hex()}
+	mempoolSeen.sighted(hash, time.Now())
+	if tx, _, err := nodeOf(ctx).TransactionByHash(ctx, hash); err == nil {
+		if sender, err := types.LatestSignerForChainID(tx.ChainId()).Sender(tx); err == nil {
+			pending.From = sender.Hex()
+		}
+		if tx.To() != nil {
+			pending.To = tx.To().Hex()
+		}
+		nonce := tx.Nonce()
+		pending.Value, pending.Nonce = weiToEther(tx.Value()).String(), &nonce
+	}
+	feed.publish(LIVE_EVENT_PENDING, pending)
+}
+
+/*
+liveEvents function: GET /events, a Server-Sent Events stream of new blocks
+and pending transactions; the stream ends after LIVE_STREAM_SPAN, within the
+server timeouts, and the browser resumes it from its Last-Event-ID. A new
+stream starts after the ?after= event, the one its page was rendered with
+*/
+func liveEvents(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return &explorerError{Kind: ErrInternal, Message: "Event streams are not supported by this server"}
+	}
+	resumeFrom := r.Header.Get("Last-Event-ID")
+	if resumeFrom == "" {
+		resumeFrom = r.URL.Query().Get("after")
+	}
+	lastID, _ := strconv.ParseUint(resumeFrom, 10, 64)
+	feed := networkOf(r.Context()).live()
+	subscriber, missed := feed.subscribe(lastID)
+	defer feed.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", LIVE_RETRY_MS)
+	for _, event := range missed {
+		writeLiveEvent(w, event)
+	}
+	flusher.Flush()
+
+	end := time.NewTimer(LIVE_STREAM_SPAN)
+	defer end.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-end.C:
+			return nil
+		case event := <-subscriber:
+			writeLiveEvent(w, event)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeLiveEvent(w http.ResponseWriter, event liveEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// *********************** mempool **************************************
+
+// where the pool was read from, the first the node answers
+const (
+	MEMPOOL_SOURCE_TXPOOL        = "txpool_content"
+	MEMPOOL_SOURCE_PENDING_TXS   = "eth_pendingTransactions"
+	MEMPOOL_SOURCE_PENDING_BLOCK = "pending block"
+	MEMPOOL_SEEN_LIMIT           = 10000 // default of the sightings kept before those older than an hour are dropped
+)
+
+// pools of txpool_content
+const (
+	POOL_PENDING = "pending" // executable with the next nonces
+	POOL_QUEUED  = "queued"  // waiting for a missing nonce
+)
+
+// rpcPoolTx is a transaction as the node lists it in its pool
+type rpcPoolTx struct {
+	Hash                 common.Hash     `json:"hash"`
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Value                *hexutil.Big    `json:"value"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas"`
+}
+
+// mempoolTx is one pending or queued transaction
+type mempoolTx struct {
+	Hash           common.Hash     `json:"hash"`
+	To             *common.Address `json:"to,omitempty"`
+	Nonce          uint64          `json:"nonce"`
+	Value          string          `json:"value"` // in ether
+	Gas            uint64          `json:"gas"`
+	GasPrice       string          `json:"gasPrice"`                 // in wei, the fee cap of dynamic fee transactions
+	PriorityFee    string          `json:"priorityFee,omitempty"`    // in wei, dynamic fee transactions only
+	Pool           string          `json:"pool"`                     // pending or queued
+	FirstSeen      time.Time       `json:"firstSeen"`                // when the explorer first saw it
+	Age            string          `json:"age"`                      // since FirstSeen
+	NonceGap       bool            `json:"nonceGap"`                 // an earlier nonce is missing, it cannot be mined
+	ReplacedNonces int             `json:"replacedNonces,omitempty"` // other pool entries with the same nonce
+}
+
+// mempoolSender groups the pool transactions of one sender by nonce
+type mempoolSender struct {
+	From          common.Address `json:"from"`
+	AccountNonce  uint64         `json:"accountNonce"` // nonce of the next transaction to mine
+	MissingNonces []uint64       `json:"missingNonces,omitempty"`
+	Stuck         bool           `json:"stuck"`
+	Transactions  []mempoolTx    `json:"transactions"`
+}
+
+// mempoolPage is the content of /pending
+type mempoolPage struct {
+	Source  string          `json:"source"`
+	Pending int             `json:"pending"`
+	Queued  int             `json:"queued"`
+	Stuck   int             `json:"stuck"` // transactions behind a nonce gap
+	Senders []mempoolSender `json:"senders"`
+}
+
+// poolSightings remembers when the explorer first saw each pool transaction,
+// the node does not tell
+type poolSightings struct {
+	mu   sync.Mutex
+	seen map[common.Hash]time.Time
+}
+
+var mempoolSeen = &poolSightings{seen: make(map[common.Hash]time.Time)}
+
+/*
+sighted function: the first time hash was seen, now for a new one
+*/
+func (s *poolSightings) sighted(hash common.Hash, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if first, ok := s.seen[hash]; ok {
+		return first
+	}
+	if len(s.seen) >= config.Caches.PoolSightings {
+		for known, first := range s.seen {
+			if now.Sub(first) > time.Hour {
+				delete(s.seen, known)
+			}
+		}
+	}
+	s.seen[hash] = now
+	return now
+}
+
+/*
+readPool function: lists the pool of the node by pool name, with
+txpool_content where the node has it and the pending transactions otherwise;
+those are all reported as pending
+*/
+func readPool(ctx context.Context, node *EthRPC) (map[string][]rpcPoolTx, string, error) {
+	var content map[string]map[common.Address]map[string]rpcPoolTx
+	err := node.call(ctx, "txpool_content", &content)
+	if err == nil {
+		pools := make(map[string][]rpcPoolTx)
+		for pool, senders := range content {
+			for _, nonces := range senders {
+				for _, tx := range nonces {
+					pools[pool] = append(pools[pool], tx)
+				}
+			}
+		}
+		return pools, MEMPOOL_SOURCE_TXPOOL, nil
+	}
+	if !isMethodUnsupported(err) {
+		return nil, "", err
+	}
+
+	var pending []rpcPoolTx
+	err = node.call(ctx, "eth_pendingTransactions", &pending)
+	if err == nil {
+		return map[string][]rpcPoolTx{POOL_PENDING: pending}, MEMPOOL_SOURCE_PENDING_TXS, nil
+	}
+	if !isMethodUnsupported(err) {
+		return nil, "", err
+	}
+
+	var block struct {
+		Transactions []rpcPoolTx `json:"transactions"`
+	}
+	if err := node.call(ctx, "eth_getBlockByNumber", &block, "pending", true); err != nil {
+		return nil, "", err
+	}
+	return map[string][]rpcPoolTx{POOL_PENDING: block.Transactions}, MEMPOOL_SOURCE_PENDING_BLOCK, nil
+}
+
+/*
+isMethodUnsupported function: reports whether err means the node does not
+offer the called method
+*/
+func isMethodUnsupported(err error) bool {
+	var ethErr EthError
+	return errors.As(err, &ethErr) && ethErr.Code == -32601
+}
+
+/*
+loadMempool function: loads the pool of the node, grouped by sender and
+ordered by nonce, and flags the transactions stuck behind a missing nonce
+*/
+func loadMempool(ctx context.Context) (mempoolPage, error) {
+	node := networkOf(ctx).rpc
+	pools, source, err := readPool(ctx, node)
+	if err != nil {
+		return mempoolPage{}, nodeError("Reason: Couldn't able to read the transaction pool of "+networkOf(ctx).URL, err)
+	}
+
+	now := time.Now()
+	bySender := make(map[common.Address][]mempoolTx)
+	data := mempoolPage{Source: source, Pending: len(pools[POOL_PENDING]), Queued: len(pools[POOL_QUEUED])}
+	for pool, txs := range pools {
+		for _, tx := range txs {
+			entry := mempoolTx{
+				Hash:  tx.Hash,
+				To:    tx.To,
+				Nonce: uint64(tx.Nonce),
+				Gas:   uint64(tx.Gas),
+				Pool:  pool,
+			}
+			if tx.Value != nil {
+				entry.Value = weiToEther(tx.Value.ToInt()).String()
+			}
+			switch {
+			case tx.MaxFeePerGas != nil:
+				entry.GasPrice = tx.MaxFeePerGas.ToInt().String()
+				if tx.MaxPriorityFeePerGas != nil {
+					entry.PriorityFee = tx.MaxPriorityFeePerGas.ToInt().String()
+				}
+			case tx.GasPrice != nil:
+				entry.GasPrice = tx.GasPrice.ToInt().String()
+			}
+			entry.FirstSeen = mempoolSeen.sighted(tx.Hash, now)
+			entry.Age = now.Sub(entry.FirstSeen).Round(time.Second).String()
+			bySender[tx.From] = append(bySender[tx.From], entry)
+		}
+	}
+
+	// the account nonces tell where each sender's queue has to start
+	senders := make([]common.Address, 0, len(bySender))
+	for from := range bySender {
+		senders = append(senders, from)
+	}
+	batch := make([]BatchElem, len(senders))
+	nonces := make([]hexutil.Uint64, len(senders))
+	for i, from := range senders {
+		batch[i] = BatchElem{Method: "eth_getTransactionCount", Params: []interface{}{from, "latest"}, Result: &nonces[i]}
+	}
+	if err := node.BatchCallParallel(ctx, batch); err != nil {
+		return mempoolPage{}, nodeError("Reason: `eth_getTransactionCount` failed for the senders of the pool", err)
+	}
+
+	for i, from := range senders {
+		if batch[i].Error != nil {
+			return mempoolPage{}, nodeError("Reason: `eth_getTransactionCount` failed for "+from.Hex(), batch[i].Error)
+		}
+		sender := groupByNonce(from, uint64(nonces[i]), bySender[from])
+		for _, tx := range sender.Transactions {
+			if tx.NonceGap {
+				data.Stuck++
+			}
+		}
+		data.Senders = append(data.Senders, sender)
+	}
+	// stuck senders first, then the busiest
+	sort.Slice(data.Senders, func(i, j int) bool {
+		a, b := data.Senders[i], data.Senders[j]
+		if a.Stuck != b.Stuck {
+			return a.Stuck
+		}
+		if len(a.Transactions) != len(b.Transactions) {
+			return len(a.Transactions) > len(b.Transactions)
+		}
+		return bytes.Compare(a.From.Bytes(), b.From.Bytes()) < 0
+	})
+	return data, nil
+}
+
+/*
+groupByNonce function: orders the pool transactions of a sender by nonce and
+flags those above the first nonce missing from accountNonce on, the node
+cannot mine them until it arrives
+*/
+func groupByNonce(from common.Address, accountNonce uint64, txs []mempoolTx) mempoolSender {
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].Nonce != txs[j].Nonce {
+			return txs[i].Nonce < txs[j].Nonce
+		}
+		return txs[i].FirstSeen.Before(txs[j].FirstSeen)
+	})
+	sender := mempoolSender{From: from, AccountNonce: accountNonce}
+
+	perNonce := make(map[uint64]int)
+	for _, tx := range txs {
+		perNonce[tx.Nonce]++
+	}
+	next := accountNonce // the nonce the sender's queue continues with
+	for i := range txs {
+		tx := &txs[i]
+		tx.ReplacedNonces = perNonce[tx.Nonce] - 1
+		if tx.Nonce < next {
+			// mined meanwhile, or another entry of the same nonce
+			tx.NonceGap = sender.Stuck
+			continue
+		}
+		for ; next < tx.Nonce; next++ {
+			sender.MissingNonces = append(sender.MissingNonces, next)
+			sender.Stuck = true
+		}
+		tx.NonceGap = sender.Stuck
+		next = tx.Nonce + 1
+	}
+	sender.Transactions = txs
+	return sender
+}
+
+/*
+pendingPage function: serves /pending, the transactions waiting in the pool of
+the node
+*/
+func pendingPage(w http.ResponseWriter, r *http.Request) error {
+	data, err := loadMempool(r.Context())
+	if err != nil {
+		return err
+	}
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "pending.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+/*
+apiPending function: GET /api/v1/pending, mirrors /pending
+*/
+func apiPending(w http.ResponseWriter, r *http.Request) error {
+	data, err := loadMempool(r.Context())
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
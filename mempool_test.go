@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestGroupByNonce(t *testing.T) {
+	type entry struct {
+		nonce    uint64
+		seen     int // seconds after the first sighting
+		gap      bool
+		replaced int
+	}
+	tests := []struct {
+		name         string
+		accountNonce uint64
+		pool         []entry // as the node lists them
+		want         []entry // by nonce, then first sighting
+		missing      []uint64
+		stuck        bool
+	}{
+		{
+			name:         "contiguous",
+			accountNonce: 4,
+			pool:         []entry{{nonce: 5}, {nonce: 4}, {nonce: 6}},
+			want:         []entry{{nonce: 4}, {nonce: 5}, {nonce: 6}},
+		},
+		{
+			name:         "gap before the first",
+			accountNonce: 4,
+			pool:         []entry{{nonce: 6}, {nonce: 7}},
+			want:         []entry{{nonce: 6, gap: true}, {nonce: 7, gap: true}},
+			missing:      []uint64{4, 5},
+			stuck:        true,
+		},
+		{
+			name:         "gap in the middle",
+			accountNonce: 0,
+			pool:         []entry{{nonce: 0}, {nonce: 1}, {nonce: 3}, {nonce: 4}},
+			want:         []entry{{nonce: 0}, {nonce: 1}, {nonce: 3, gap: true}, {nonce: 4, gap: true}},
+			missing:      []uint64{2},
+			stuck:        true,
+		},
+		{
+			name:         "replacement by the same nonce",
+			accountNonce: 2,
+			pool:         []entry{{nonce: 3, seen: 1}, {nonce: 2, seen: 5}, {nonce: 2, seen: 0}},
+			want:         []entry{{nonce: 2, seen: 0, replaced: 1}, {nonce: 2, seen: 5, replaced: 1}, {nonce: 3, seen: 1}},
+		},
+		{
+			name:         "replacement behind a gap",
+			accountNonce: 0,
+			pool:         []entry{{nonce: 2, seen: 3}, {nonce: 2, seen: 1}},
+			want:         []entry{{nonce: 2, seen: 1, gap: true, replaced: 1}, {nonce: 2, seen: 3, gap: true, replaced: 1}},
+			missing:      []uint64{0, 1},
+			stuck:        true,
+		},
+		{
+			name:         "mined meanwhile",
+			accountNonce: 5,
+			pool:         []entry{{nonce: 4}, {nonce: 5}},
+			want:         []entry{{nonce: 4}, {nonce: 5}},
+		},
+	}
+	from := common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	start := time.Unix(1700000000, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := make([]mempoolTx, len(tt.pool))
+			for i, e := range tt.pool {
+				pool[i] = mempoolTx{Nonce: e.nonce, FirstSeen: start.Add(time.Duration(e.seen) * time.Second)}
+			}
+			sender := groupByNonce(from, tt.accountNonce, pool)
+
+			got := make([]entry, len(sender.Transactions))
+			for i, tx := range sender.Transactions {
+				got[i] = entry{tx.Nonce, int(tx.FirstSeen.Sub(start) / time.Second), tx.NonceGap, tx.ReplacedNonces}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("transactions %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(sender.MissingNonces, tt.missing) {
+				t.Errorf("missing nonces %v, want %v", sender.MissingNonces, tt.missing)
+			}
+			if sender.Stuck != tt.stuck || sender.From != from || sender.AccountNonce != tt.accountNonce {
+				t.Errorf("sender %s nonce %d stuck %v, want %s nonce %d stuck %v",
+					sender.From.Hex(), sender.AccountNonce, sender.Stuck, from.Hex(), tt.accountNonce, tt.stuck)
+			}
+		})
+	}
+}
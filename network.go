@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gorilla/mux"
+)
+
+// *********************** networks **************************************
+
+const (
+	DEFAULT_NETWORK     = "local"        // name of the -node endpoint
+	NETWORK_PATH_PREFIX = "/n/{network}" // pages and api of a network other than the default one
+	MAX_ADDED_NETWORKS  = 20             // endpoints added from the browser at most
+)
+
+// names of networks are path segments
+var (
+	networkNamePattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	networkNameSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// network is one node the explorer shows, with its own client and caches
+type network struct {
+	Name  string
+	URL   string
+	Added bool // added from the browser, not configured
+
+	mu      sync.Mutex
+	client  *ethclient.Client
+	rpc     *EthRPC
+	chainID *big.Int
+	index   *blockIndex // the on-disk block index, only the default network has one
+
+	// account the faucet sends from, -faucet-funder for the default network;
+	// empty for the first account of the node
+	faucetFunder string
+
+	feed     *liveFeed
+	feedOnce sync.Once
+}
+
+// networkSummary is a network as listed in the switcher and the api
+type networkSummary struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	ChainID string `json:"chainId,omitempty"` // empty until the node answered
+	Added   bool   `json:"added"`
+}
+
+// networkRegistry holds the configured networks and those added from the browser
+type networkRegistry struct {
+	mu       sync.RWMutex
+	networks map[string]*network
+	fallback *network // serves the paths without a network
+}
+
+var networks = &networkRegistry{networks: make(map[string]*network)}
+
+/*
+connect function: dials the node of the network once it is reachable; a
+websocket or ipc node that is down fails here instead of on every call
+*/
+func (n *network) connect() (*ethclient.Client, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.client == nil {
+		client, err := dialNode(n.URL)
+		if err != nil {
+			return nil, err
+		}
+		n.client = client
+	}
+	return n.client, nil
+}
+
+/*
+release function: gives up the connections of a network that is not kept;
+those another network, the index or a concurrent add still uses stay open
+*/
+func (n *network) release() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.client != nil {
+		releaseNode(n.URL)
+		n.client = nil
+	}
+	if n.rpc != nil && n.rpc.stream != nil {
+		releaseStream(n.URL)
+		n.rpc.stream = nil
+	}
+}
+
+/*
+chainIDOf function: the chain id of the network, asked once from the node
+*/
+func (n *network) chainIDOf(ctx context.Context) (*big.Int, error) {
+	n.mu.Lock()
+	known := n.chainID
+	n.mu.Unlock()
+	if known != nil {
+		return known, nil
+	}
+	client, err := n.connect()
+	if err != nil {
+		return nil, err
+	}
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	n.chainID = chainID
+	n.mu.Unlock()
+	return chainID, nil
+}
+
+/*
+live function: the live feed of the network, started on first use so only
+the networks someone looks at are followed
+*/
+func (n *network) live() *liveFeed {
+	n.feedOnce.Do(func() {
+		n.feed = newLiveFeed()
+		go n.feed.run(n)
+	})
+	return n.feed
+}
+
+func (n *network) summary() networkSummary {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	summary := networkSummary{Name: n.Name, URL: n.URL, Added: n.Added}
+	if n.chainID != nil {
+		summary.ChainID = n.chainID.String()
+	}
+	return summary
+}
+
+/*
+add function: registers a configured network; the first one added serves the
+paths without a network
+*/
+func (reg *networkRegistry) add(name, url string) (*network, error) {
+	if !networkNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("network name %q must be lowercase letters, digits and dashes", name)
+	}
+	if url == "" {
+		return nil, fmt.Errorf("network %s has no endpoint", name)
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.networks[name]; ok {
+		return nil, fmt.Errorf("network %s is configured twice", name)
+	}
+	net := &network{Name: name, URL: url, rpc: newClient(url)}
+	reg.networks[name] = net
+	if reg.fallback == nil {
+		reg.fallback = net
+	}
+	return net, nil
+}
+
+/*
+addEndpoint function: registers an endpoint added from the browser once its
+node answered with a chain id; the network of an endpoint already known is
+returned instead. An empty name is made from the endpoint. The node is only
+dialled once the endpoint could be added, and released again when it fails
+*/
+func (reg *networkRegistry) addEndpoint(ctx context.Context, name, url string) (*network, error) {
+	url = strings.TrimSpace(url)
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") && !isWebsocketEndpoint(url) {
+		return nil, invalidInput("Endpoint must be an http(s):// or ws(s):// url")
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = networkNameFor(url)
+	}
+	if !networkNamePattern.MatchString(name) {
+		return nil, invalidInput("Network name must be lowercase letters, digits and dashes")
+	}
+	reg.mu.RLock()
+	known, err := reg.addable(name, url)
+	reg.mu.RUnlock()
+	if known != nil || err != nil {
+		return known, err
+	}
+
+	net := &network{Name: name, URL: url, Added: true, rpc: newClient(url)}
+	if _, err := net.chainIDOf(ctx); err != nil {
+		net.release()
+		return nil, nodeError("Reason: Couldn't able to reach "+url, err)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	// checked again, another request may have added a network meanwhile
+	if known, err := reg.addable(name, url); known != nil || err != nil {
+		net.release()
+		return known, err
+	}
+	reg.networks[name] = net
+	return net, nil
+}
+
+/*
+addable function: the network already serving url, or why a network name for
+url cannot be added; both nil when it can. The caller holds reg.mu
+*/
+func (reg *networkRegistry) addable(name, url string) (*network, error) {
+	added := 0
+	for _, known := range reg.networks {
+		if known.URL == url {
+			return known, nil
+		}
+		if known.Added {
+			added++
+		}
+	}
+	if added >= MAX_ADDED_NETWORKS {
+		return nil, invalidInput(fmt.Sprintf("No more than %d networks can be added", MAX_ADDED_NETWORKS))
+	}
+	if _, ok := reg.networks[name]; ok {
+		return nil, invalidInput("Network " + name + " already exists with another endpoint")
+	}
+	return nil, nil
+}
+
+// networkNameFor makes a network name of an endpoint, e.g. 127-0-0-1-8545
+func networkNameFor(url string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		url = url[i+3:]
+	}
+	name := strings.Trim(networkNameSeparators.ReplaceAllString(strings.ToLower(url), "-"), "-")
+	if name == "" {
+		name = "node"
+	}
+	return name
+}
+
+func (reg *networkRegistry) get(name string) *network {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.networks[name]
+}
+
+func (reg *networkRegistry) defaultNetwork() *network {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.fallback
+}
+
+/*
+list function: the networks, configured ones first, for the switcher
+*/
+func (reg *networkRegistry) list() []networkSummary {
+	reg.mu.RLock()
+	list := make([]networkSummary, 0, len(reg.networks))
+	for _, net := range reg.networks {
+		list = append(list, net.summary())
+	}
+	fallback := reg.fallback.Name
+	reg.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Name == fallback) != (list[j].Name == fallback) {
+			return list[i].Name == fallback
+		}
+		if list[i].Added != list[j].Added {
+			return !list[i].Added
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// *********************** request network **************************************
+
+type networkContextKey struct{}
+
+// requestNetwork is the network a request or background job works on
+type requestNetwork struct {
+	network *network
+	base    string // path prefix of the links of the pages, empty for the default network
+}
+
+/*
+withNetworkContext function: a context working on net, with page links under
+base
+*/
+func withNetworkContext(ctx context.Context, net *network, base string) context.Context {
+	return context.WithValue(ctx, networkContextKey{}, requestNetwork{network: net, base: base})
+}
+
+/*
+networkOf function: the network of a request, the default one when the
+context carries none
+*/
+func networkOf(ctx context.Context) *network {
+	if current, ok := ctx.Value(networkContextKey{}).(requestNetwork); ok {
+		return current.network
+	}
+	return networks.defaultNetwork()
+}
+
+// nodeOf is the client of the network of ctx, connected by withNetwork
+func nodeOf(ctx context.Context) *ethclient.Client {
+	net := networkOf(ctx)
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	return net.client
+}
+
+// networkHome is the home page of net, without a prefix for the default network
+func networkHome(net *network) string {
+	if net == networks.defaultNetwork() {
+		return "/homepage"
+	}
+	return "/n/" + net.Name + "/homepage"
+}
+
+// networkBase is the path prefix of the links of a page, empty for the default network
+func networkBase(ctx context.Context) string {
+	current, _ := ctx.Value(networkContextKey{}).(requestNetwork)
+	return current.base
+}
+
+/*
+withNetwork middleware: resolves the network named in the path, the default
+one on paths without a network, and connects to its node before the handler
+runs
+*/
+func withNetwork(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		net, base := networks.defaultNetwork(), ""
+		if name, ok := mux.Vars(r)["network"]; ok {
+			if net = networks.get(name); net == nil {
+				renderError(w, r, notFound("Network "+name+" is not known to this explorer", nil))
+				return
+			}
+			base = "/n/" + name
+		}
+		ctx := withNetworkContext(r.Context(), net, base)
+		if _, err := net.connect(); err != nil {
+			renderError(w, r.WithContext(ctx), nodeError("Reason: Couldn't able to connect to "+net.URL, err))
+			return
+		}
+		net.chainIDOf(ctx) // for the switcher, asked once
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// *********************** networks page **************************************
+
+/*
+networksHandler function: serves /networks, the list of networks; a POST adds
+an endpoint and opens its home page
+*/
+func networksHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		net, err := networks.addEndpoint(r.Context(), r.FormValue("name"), r.FormValue("url"))
+		if err != nil {
+			return err
+		}
+		http.Redirect(w, r, networkHome(net), http.StatusSeeOther)
+		return nil
+	}
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "networks.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, networks.list())
+}
+
+/*
+apiNetworks function: GET /api/v1/networks lists the networks, POST adds the
+endpoint of the url form value under name
+*/
+func apiNetworks(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		net, err := networks.addEndpoint(r.Context(), r.FormValue("name"), r.FormValue("url"))
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, net.summary())
+	}
+	return writeJSON(w, http.StatusOK, networks.list())
+}
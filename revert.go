@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// *********************** revert reasons **************************************
+
+// how a transaction failed
+const (
+	FAILURE_REVERT       = "revert"       // require/revert with a message, or without data
+	FAILURE_PANIC        = "panic"        // Panic(uint256) from a failed assert or checked operation
+	FAILURE_CUSTOM_ERROR = "custom error" // revert with a solidity custom error
+	FAILURE_OUT_OF_GAS   = "out of gas"
+	FAILURE_UNKNOWN      = "unknown" // e.g. an invalid opcode, or a replay that did not fail
+)
+
+// selectors of the revert data solidity emits itself
+var (
+	errorStringSelector = []byte{0x08, 0xc3, 0x79, 0xa0} // Error(string)
+	panicSelector       = []byte{0x4e, 0x48, 0x7b, 0x71} // Panic(uint256)
+)
+
+// panicReasons explains the Panic(uint256) codes of solidity >= 0.8
+var panicReasons = map[uint64]string{
+	0x00: "generic compiler inserted panic",
+	0x01: "assertion failed",
+	0x11: "arithmetic overflow or underflow",
+	0x12: "division or modulo by zero",
+	0x21: "conversion to an invalid enum value",
+	0x22: "incorrectly encoded storage byte array",
+	0x31: "pop() on an empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to an uninitialised internal function",
+}
+
+// txFailure explains why a transaction failed
+type txFailure struct {
+	Kind       string       `json:"kind"`
+	Reason     string       `json:"reason"`
+	Error      string       `json:"error,omitempty"` // custom error signature
+	Args       []decodedArg `json:"args,omitempty"`
+	Source     string       `json:"source,omitempty"` // how the custom error was decoded
+	Candidates []string     `json:"candidates,omitempty"`
+	Data       string       `json:"data,omitempty"` // raw revert data
+	Note       string       `json:"note,omitempty"` // caveat of the replay
+}
+
+/*
+decodeRevertData function: explains the data a call reverted with: an
+Error(string) message, a Panic(uint256) code or a custom error, looked up in
+the abi of contract, then in every registered abi, then in the signature
+database
+*/
+func decodeRevertData(host string, contract *common.Address, data []byte) *txFailure {
+	failure := &txFailure{Kind: FAILURE_REVERT, Data: hexutil.Encode(data)}
+	if len(data) == 0 {
+		failure.Reason, failure.Data = "reverted without a reason", ""
+		return failure
+	}
+	if len(data) < 4 {
+		failure.Kind, failure.Reason = FAILURE_UNKNOWN, "reverted with malformed data"
+		return failure
+	}
+
+	selector := data[:4]
+	switch {
+	case bytes.Equal(selector, errorStringSelector):
+		stringType, _ := abi.NewType("string", "", nil)
+		if values, err := (abi.Arguments{{Type: stringType}}).Unpack(data[4:]); err == nil {
+			failure.Reason = values[0].(string)
+			return failure
+		}
+	case bytes.Equal(selector, panicSelector) && len(data) == 36:
+		code := new(big.Int).SetBytes(data[4:])
+		failure.Kind = FAILURE_PANIC
+		explanation, ok := panicReasons[code.Uint64()]
+		if !code.IsUint64() || !ok {
+			explanation = "unknown panic code"
+		}
+		failure.Reason = fmt.Sprintf("Panic(0x%x): %s", code, explanation)
+		return failure
+	}
+
+	failure.Kind = FAILURE_CUSTOM_ERROR
+	if name, customError, ok := contractABIs.customError(host, contract, selector); ok {
+		if values, err := customError.Inputs.Unpack(data[4:]); err == nil {
+			failure.Error, failure.Args, failure.Source = customError.Sig, decodeArgs(customError.Inputs, values), DECODED_FROM_ABI
+			failure.Reason = customError.Name
+			if name != "" {
+				failure.Reason = name + "." + customError.Name
+			}
+			return failure
+		}
+	}
+	if candidates := signatures.functionCandidates(selector); len(candidates) > 0 {
+		failure.Candidates, failure.Source = candidates, DECODED_FROM_SIGNATURES
+		for _, signature := range candidates {
+			name, args, err := parseSignature(signature)
+			if err != nil {
+				continue
+			}
+			if values, ok := unpackExactly(args, data[4:]); ok {
+				failure.Error, failure.Args, failure.Reason = signature, decodeArgs(args, values), name
+				return failure
+			}
+		}
+	}
+	failure.Reason = "unknown custom error " + hexutil.Encode(selector)
+	return failure
+}
+
+/*
+customError function: the custom error with the given selector, from the abi
+registered for contract first and from any other registered abi after, since
+the error may come from a contract further down the call
+*/
+func (reg *abiRegistry) customError(host string, contract *common.Address, selector []byte) (string, abi.Error, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	find := func(entry *contractABI) (abi.Error, bool) {
+		for _, customError := range entry.ABI.Errors {
+			if bytes.Equal(customError.ID[:4], selector) {
+				return customError, true
+			}
+		}
+		return abi.Error{}, false
+	}
+	if contract != nil {
+		if entry := reg.contracts[host][*contract]; entry != nil {
+			if customError, ok := find(entry); ok {
+				return entry.Name, customError, true
+			}
+		}
+	}
+	for _, entry := range reg.contracts[host] {
+		if customError, ok := find(entry); ok {
+			return entry.Name, customError, true
+		}
+	}
+	return "", abi.Error{}, false
+}
+
+/*
+explainFailure function: replays a failed transaction with eth_call on the
+state of its parent block and explains the failure; nil for a successful
+transaction
+*/
+func explainFailure(ctx context.Context, tx *types.Transaction, receipt *types.Receipt) *txFailure {
+	if receipt == nil || receipt.Status == types.ReceiptStatusSuccessful {
+		return nil
+	}
+	outOfGas := receipt.GasUsed >= tx.Gas()
+
+	sender, err := types.LatestSignerForChainID(tx.ChainId()).Sender(tx)
+	if err != nil {
+		return &txFailure{Kind: FAILURE_UNKNOWN, Reason: "couldn't recover the sender to replay the transaction"}
+	}
+	msg := ethereum.CallMsg{
+		From:       sender,
+		To:         tx.To(),
+		Gas:        tx.Gas(),
+		Value:      tx.Value(),
+		Data:       tx.Data(),
+		AccessList: tx.AccessList(),
+	}
+	parent := new(big.Int).Sub(receipt.BlockNumber, big.NewInt(1))
+	_, err = nodeOf(ctx).CallContract(ctx, msg, parent)
+
+	var failure *txFailure
+	var dataErr rpc.DataError
+	var data []byte
+	var reason string
+	reverted := false
+	if errors.As(err, &dataErr) {
+		data, reason, reverted = revertData(dataErr.ErrorData())
+	}
+	switch {
+	case err == nil:
+		return &txFailure{Kind: FAILURE_UNKNOWN, Reason: "the replay did not fail",
+			Note: "it depends on state changed by earlier transactions of its block"}
+	case reverted:
+		failure = decodeRevertData(networkOf(ctx).URL, tx.To(), data)
+		if len(data) == 0 && reason != "" {
+			failure.Reason = reason
+		}
+	case strings.Contains(err.Error(), "execution reverted"):
+		failure = decodeRevertData(networkOf(ctx).URL, tx.To(), nil)
+	case strings.Contains(err.Error(), "out of gas") || strings.Contains(err.Error(), "gas required exceeds"),
+		outOfGas && !strings.Contains(err.Error(), "invalid opcode"): // nodes word it differently
+		failure = &txFailure{Kind: FAILURE_OUT_OF_GAS, Reason: fmt.Sprintf("ran out of gas (used all %d)", receipt.GasUsed)}
+	default:
+		failure = &txFailure{Kind: FAILURE_UNKNOWN, Reason: err.Error()}
+	}
+	if receipt.TransactionIndex > 0 {
+		failure.Note = "replayed on the state before its block, without the earlier transactions of the block"
+	}
+	return failure
+}
+
+/*
+revertData function: the revert data in the error data of a failed call, with
+the reason the node decoded itself, and whether the call reverted. Geth sends
+the data as a hex string, Ganache v6 as an object keyed by the transaction:
+{"0x<hash>": {"error": "revert", "return": "0x...", "reason": "..."}}
+*/
+func revertData(errData interface{}) ([]byte, string, bool) {
+	switch errData := errData.(type) {
+	case string:
+		data, _ := hexutil.Decode(errData)
+		return data, "", true
+	case map[string]interface{}:
+		for key, value := range errData {
+			result, ok := value.(map[string]interface{})
+			if !ok || !strings.HasPrefix(key, "0x") {
+				continue // the stack and name of the exception
+			}
+			if kind, _ := result["error"].(string); kind != "revert" {
+				return nil, "", false // e.g. invalid opcode
+			}
+			returned, _ := result["return"].(string)
+			reason, _ := result["reason"].(string)
+			data, _ := hexutil.Decode(returned)
+			return data, reason, true
+		}
+	}
+	return nil, "", false
+}
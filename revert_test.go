@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// revert data of Error("not owner")
+const notOwnerData = "0x08c379a0" +
+	"0000000000000000000000000000000000000000000000000000000000000020" +
+	"0000000000000000000000000000000000000000000000000000000000000009" +
+	"6e6f74206f776e65720000000000000000000000000000000000000000000000"
+
+func TestRevertData(t *testing.T) {
+	tests := []struct {
+		name     string
+		errData  string // as the node sends it in error.data
+		data     string
+		reason   string
+		reverted bool
+	}{
+		{name: "geth", errData: `"` + notOwnerData + `"`, data: notOwnerData, reverted: true},
+		{name: "geth without data", errData: `"0x"`, data: "0x", reverted: true},
+		{
+			name:     "ganache v6",
+			errData:  `{"0xf3a5b1c2d4e6f70811223344556677889900aabbccddeeff0011223344556677":{"error":"revert","program_counter":130,"return":"` + notOwnerData + `","reason":"not owner"},"stack":"RuntimeError: VM Exception","name":"RuntimeError"}`,
+			data:     notOwnerData,
+			reason:   "not owner",
+			reverted: true,
+		},
+		{
+			name:     "ganache v6 without data",
+			errData:  `{"0xf3a5b1c2d4e6f70811223344556677889900aabbccddeeff0011223344556677":{"error":"revert","program_counter":12,"return":"0x"},"stack":"","name":"RuntimeError"}`,
+			data:     "0x",
+			reverted: true,
+		},
+		{
+			name:    "ganache v6 invalid opcode",
+			errData: `{"0xf3a5b1c2d4e6f70811223344556677889900aabbccddeeff0011223344556677":{"error":"invalid opcode","program_counter":7,"return":"0x"},"stack":"","name":"RuntimeError"}`,
+			data:    "0x",
+		},
+		{name: "only stack and name", errData: `{"stack":"","name":"RuntimeError"}`, data: "0x"},
+		{name: "number", errData: `3`, data: "0x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errData interface{}
+			if err := json.Unmarshal([]byte(tt.errData), &errData); err != nil {
+				t.Fatal(err)
+			}
+			data, reason, reverted := revertData(errData)
+			if hexutil.Encode(data) != tt.data || reason != tt.reason || reverted != tt.reverted {
+				t.Errorf("revertData = %s, %q, %v; want %s, %q, %v", hexutil.Encode(data), reason, reverted, tt.data, tt.reason, tt.reverted)
+			}
+		})
+	}
+}
+
+func TestDecodeRevertData(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		kind   string
+		reason string
+	}{
+		{name: "no data", data: "0x", kind: FAILURE_REVERT, reason: "reverted without a reason"},
+		{name: "error string", data: notOwnerData, kind: FAILURE_REVERT, reason: "not owner"},
+		{name: "assert", data: "0x4e487b71" + "0000000000000000000000000000000000000000000000000000000000000001", kind: FAILURE_PANIC, reason: "Panic(0x1): assertion failed"},
+		{name: "overflow", data: "0x4e487b71" + "0000000000000000000000000000000000000000000000000000000000000011", kind: FAILURE_PANIC, reason: "Panic(0x11): arithmetic overflow or underflow"},
+		{name: "unknown panic", data: "0x4e487b71" + "00000000000000000000000000000000000000000000000000000000000000ff", kind: FAILURE_PANIC, reason: "Panic(0xff): unknown panic code"},
+		{name: "malformed", data: "0x08c3", kind: FAILURE_UNKNOWN, reason: "reverted with malformed data"},
+		{name: "unknown custom error", data: "0xdeadbeef", kind: FAILURE_CUSTOM_ERROR, reason: "unknown custom error 0xdeadbeef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failure := decodeRevertData("http://127.0.0.1:8545", nil, hexutil.MustDecode(tt.data))
+			if failure.Kind != tt.kind || failure.Reason != tt.reason {
+				t.Errorf("decodeRevertData(%s) = %s %q, want %s %q", tt.data, failure.Kind, failure.Reason, tt.kind, tt.reason)
+			}
+		})
+	}
+}
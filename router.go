@@ -6,98 +6,113 @@ package main
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"html/template"
 	"log"
 	"math/big"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/gorilla/mux"
 )
 
 // *********************** variable ********************************************
 
-// NetworkHost for holding the host
-var NetworkHost = "http://0.0.0.0:8545" // Ganache host
-
-var client *ethclient.Client // for client to access globally
-
 // *********************** structs *********************************************
 
 // for overall ganache statistics
 type sysInfo struct {
-	NumBlock                string
-	NetworkID               *big.Int
-	PendingTransactionCount uint
-	SuggestedGasPrice       *big.Int
-	BlockDetails            []blockInfo
-	AccountDetails          []accountInfo
-	NextPage                int64
-	PrevPage                int64
+	NumBlock                string        `json:"numBlock"`
+	NetworkID               *big.Int      `json:"networkId"`
+	PendingTransactionCount uint          `json:"pendingTransactionCount"`
+	SuggestedGasPrice       *big.Int      `json:"suggestedGasPrice"`
+	BlockDetails            []blockInfo   `json:"blocks"`
+	AccountDetails          []accountInfo `json:"accounts"`
+	NextPage                int64         `json:"nextPage"`
+	PrevPage                int64         `json:"prevPage"`
+	LiveEventID             uint64        `json:"liveEventId"` // the live feed resumes after it
 }
 
 // for block details
 type blockInfo struct {
-	Block           string
-	BlockHash       string
-	BlockNonce      uint64
-	Transactions    int
-	Transactionhash string
-	GasUsed         uint64
-	MinedOn         time.Time
-	Difficulty      *big.Int
-	Size            common.StorageSize
-	Gaslimit        uint64
-	ParentHash      string
-	UncleHash       string
-	TxnStatus       string
+	Block           string             `json:"number"`
+	BlockHash       string             `json:"hash"`
+	BlockNonce      uint64             `json:"nonce"`
+	Transactions    int                `json:"transactionCount"`
+	Transactionhash string             `json:"transactionHash,omitempty"`
+	GasUsed         uint64             `json:"gasUsed"`
+	MinedOn         time.Time          `json:"minedOn"`
+	Difficulty      *big.Int           `json:"difficulty,omitempty"`
+	Size            common.StorageSize `json:"size,omitempty"`
+	Gaslimit        uint64             `json:"gasLimit,omitempty"`
+	ParentHash      string             `json:"parentHash,omitempty"`
+	UncleHash       string             `json:"uncleHash,omitempty"`
+	BaseFee         *big.Int           `json:"baseFee,omitempty"` // post-London blocks only
+	Miner           string             `json:"miner,omitempty"`
+	ExtraData       string             `json:"extraData,omitempty"`
+	ExtraDataText   string             `json:"extraDataText,omitempty"`           // extra data when it is printable
+	TxList          []blockTx          `json:"transactions,omitempty"`            // block details page only
+	Withdrawals     []withdrawalInfo   `json:"withdrawals,omitempty"`             // post-Shanghai blocks only
+	Unsupported     []unsupportedTx    `json:"unsupportedTransactions,omitempty"` // of types not decoded, left out of TxList
+	Uncles          []string           `json:"uncles,omitempty"`
+	TxnStatus       string             `json:"transactionStatus,omitempty"`
+	TxnFailure      *txFailure         `json:"transactionFailure,omitempty"` // why the transaction failed
 }
 
 // for ganache Default Account Details
 type accountInfo struct {
-	AccAddress  string
-	AccBalance  string
-	AccTXNCount uint64
-	AccIndex    int
+	AccAddress  string `json:"address"`
+	AccBalance  string `json:"balance"`
+	AccTXNCount uint64 `json:"transactionCount"`
+	AccIndex    int    `json:"index"`
 }
 
 // for ganache Default Account Details
 type accDetails struct {
-	AccAddress  string
-	AccBalance  string
-	AccTXNCount uint64
+	AccAddress  string `json:"address"`
+	AccBalance  string `json:"balance"`
+	AccTXNCount uint64 `json:"transactionCount"`
 }
 
 // for transaction details
 type txDetails struct {
-	TxHash        string
-	TxGas         uint64
-	TxGasPrice    uint64
-	TxNonce       uint64
-	TxToAddress   string
-	TxFromAddress string
-	TxData        string
-	TxValue       *big.Int
-	TxValueInEth  *big.Float
+	TxHash        string       `json:"hash"`
+	TxGas         uint64       `json:"gas"`
+	TxGasPrice    uint64       `json:"gasPrice"`
+	TxNonce       uint64       `json:"nonce"`
+	TxToAddress   string       `json:"to"`
+	TxFromAddress string       `json:"from"`
+	TxData        string       `json:"data"`
+	TxDecoded     *decodedCall `json:"decoded,omitempty"` // set when the called contract has an abi
+	TxValue       *big.Int     `json:"value"`
+	TxValueInEth  *big.Float   `json:"valueInEth"`
 }
 
 // for transaction details
 type txPages struct {
-	BlockHash         string
-	BlockNumber       *big.Int
-	Totaltransactions int
-	TransactionStatus string
-	TxDetails         []txDetails
-	TokenTransfers    []TokenTransferLog
+	BlockHash         string             `json:"blockHash"`
+	BlockNumber       *big.Int           `json:"blockNumber"`
+	Totaltransactions int                `json:"totalTransactions"`
+	TransactionStatus string             `json:"transactionStatus,omitempty"`
+	Failure           *txFailure         `json:"failure,omitempty"` // why the transaction failed, transaction page only
+	TxDetails         []txDetails        `json:"transactions"`
+	TokenTransfers    []TokenTransferLog `json:"tokenTransfers"`
+	ERC721Transfers   []NFTTransferLog   `json:"erc721Transfers"`
+	ERC1155Transfers  []NFTTransferLog   `json:"erc1155Transfers"`
+	EventLogs         []decodedLog       `json:"eventLogs"`
+	CallTrace         *callTraceFrame    `json:"callTrace,omitempty"`   // transaction page only
+	TraceStatus       string             `json:"traceStatus,omitempty"` // why CallTrace is missing
 }
 
-// for error logs
+// for error logs, rendered by 404.html
 type txLogs struct {
 	Status   uint64
 	Log      string
@@ -110,46 +125,92 @@ func weiToEther(wei *big.Int) *big.Float {
 	return new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(params.Ether))
 }
 
+/*
+etherToWei function: parses an ether amount like 1.5 into wei, exactly
+*/
+func etherToWei(ether string) (*big.Int, error) {
+	amount, ok := new(big.Rat).SetString(ether)
+	if !ok || amount.Sign() < 0 {
+		return nil, invalidInput("Amount must be a positive number of ether, like 1.5")
+	}
+	wei := amount.Mul(amount, new(big.Rat).SetInt(big.NewInt(params.Ether)))
+	if !wei.IsInt() {
+		return nil, invalidInput("Amount has more than 18 decimals")
+	}
+	return wei.Num(), nil
+}
+
 // *********************** block details ***************************************
 
 /*
-blockInDetails function: fetches the block details based on hash
+blockInDetails function: fetches the block details based on number or hash
 */
-func blockInDetails(w http.ResponseWriter, r *http.Request) {
+func blockInDetails(w http.ResponseWriter, r *http.Request) error {
 	/* local variables */
-	var blockHash common.Hash
+	var qss string
 
 	// parsing the request
 	for _, qs := range r.URL.Query() {
-		blockHash = common.HexToHash(qs[0])
+		qss = qs[0]
 	}
-	// client request for the block
 
-	blockDetails, blockByHashErr := client.BlockByHash(context.Background(), blockHash)
-	kickBack(blockByHashErr,
-		"Reason: `@BlockByHash` failed. Couldn't able to fetch block.")
+	data, err := loadBlockInfo(r.Context(), qss)
+	if err != nil {
+		return err
+	}
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "blockDetails.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+/*
+loadBlockInfo function: loads the block details, with every transaction, of
+the block identified by the given number or hash
+*/
+func loadBlockInfo(ctx context.Context, qss string) (blockInfo, error) {
+	// client request for the block
+	blockDetails, err := fetchBlock(ctx, qss)
+	if err != nil {
+		return blockInfo{}, err
+	}
+	txList, err := blockTransactions(ctx, blockDetails)
+	if err != nil {
+		return blockInfo{}, err
+	}
 
 	// block creation time
 	creationTime := time.Unix(int64(blockDetails.Time()), 0)
 
 	// loading data for rendering
 	data := blockInfo{
-		Block:        blockDetails.Number().String(),
-		BlockHash:    blockHash.Hex(),
-		BlockNonce:   blockDetails.Nonce(),
-		Transactions: len(blockDetails.Transactions()),
-		GasUsed:      blockDetails.GasUsed(),
-		MinedOn:      creationTime,
-		Difficulty:   blockDetails.Difficulty(),
-		Size:         blockDetails.Size(),
-		Gaslimit:     blockDetails.GasLimit(),
-		ParentHash:   blockDetails.ParentHash().String(),
-		UncleHash:    blockDetails.UncleHash().String(),
+		Block:         blockDetails.Number().String(),
+		BlockHash:     blockDetails.Hash().Hex(),
+		BlockNonce:    blockDetails.Nonce(),
+		Transactions:  blockDetails.transactionCount(),
+		GasUsed:       blockDetails.GasUsed(),
+		MinedOn:       creationTime,
+		Difficulty:    blockDetails.Difficulty(),
+		Size:          blockDetails.Size(),
+		Gaslimit:      blockDetails.GasLimit(),
+		ParentHash:    blockDetails.ParentHash().String(),
+		UncleHash:     blockDetails.UncleHash().String(),
+		BaseFee:       blockDetails.BaseFee(),
+		Miner:         blockDetails.Coinbase().Hex(),
+		ExtraData:     hexutil.Encode(blockDetails.Extra()),
+		ExtraDataText: extraDataText(blockDetails.Extra()),
+		TxList:        txList,
+		Withdrawals:   blockWithdrawals(blockDetails),
+		Unsupported:   blockDetails.Unsupported,
+	}
+	for _, uncle := range blockDetails.UncleHashes {
+		data.Uncles = append(data.Uncles, uncle.Hex())
 	}
 
-	// render
-	tmpl := template.Must(template.ParseFiles("template/blockDetails.html"))
-	tmpl.Execute(w, data)
+	return data, nil
 }
 
 // *********************** blockshomepage **************************************
@@ -158,40 +219,51 @@ func blockInDetails(w http.ResponseWriter, r *http.Request) {
 blockPage function: fetches the block details based on number for the block
 page
 */
-func blockPage(w http.ResponseWriter, bn *big.Int) blockInfo {
+func blockPage(ctx context.Context, bn *big.Int) (blockInfo, error) {
 	var receipt *types.Receipt
 	var receiptStatus string
 	// getting block based on given number
-	block, _ := client.BlockByNumber(context.Background(), bn)
-	// kickBack(w, r, blockByNumberErr,
-	// 	"Reason: `@BlockByNumber` failed. Couldn't able to fetch block.")
+	block, blockByNumberErr := fetchBlockByNumber(ctx, bn)
+	if blockByNumberErr != nil {
+		return blockInfo{}, nodeError("Reason: `@BlockByNumber` failed. Couldn't able to fetch block "+bn.String(), blockByNumberErr)
+	}
 
 	// block creation time
 	creationTime := time.Unix(int64(block.Time()), 0)
 	var tempTxn string
-	_ = tempTxn
-	// getting transaction details
-	for _, tx := range block.Transactions() {
+	var lastTx *types.Transaction
+	// getting transaction details, all receipts in one round trip
+	receipts, err := fetchReceipts(ctx, block.Transactions())
+	if err != nil {
+		return blockInfo{}, nodeError("Reason: `eth_getTransactionReceipt` failed for the transactions of block "+bn.String(), err)
+	}
+	for i, tx := range block.Transactions() {
 		tempTxn = tx.Hash().String()
-		receipt, _ = client.TransactionReceipt(context.Background(), tx.Hash())
+		lastTx = tx
+		receipt = receipts[i]
 	}
-	if receipt.Status == uint64(1) {
+	var failure *txFailure
+	switch {
+	case receipt == nil: // a block without transactions, e.g. from interval mining
+	case receipt.Status == uint64(1):
 		receiptStatus = "SUCCESSFUL"
-	} else {
+	default:
 		receiptStatus = "FAILED"
+		failure = explainFailure(ctx, lastTx, receipt)
 	}
 	// loading data for rendering
 	blockData := blockInfo{
 		Block:           bn.String(),
-		BlockHash:       receipt.BlockHash.Hex(), //block.Hash().String(),
+		BlockHash:       block.Hash().Hex(),
 		BlockNonce:      block.Nonce(),
-		Transactions:    len(block.Transactions()),
+		Transactions:    block.transactionCount(),
 		Transactionhash: tempTxn,
 		GasUsed:         block.GasUsed(),
 		MinedOn:         creationTime,
 		TxnStatus:       receiptStatus,
+		TxnFailure:      failure,
 	}
-	return blockData
+	return blockData, nil
 }
 
 // *********************** homepage **************************************
@@ -199,20 +271,22 @@ func blockPage(w http.ResponseWriter, bn *big.Int) blockInfo {
 /*
 accountsBalance function: fetches the account details and their balance
 */
-func getAccountDetails(account common.Address, itr int) accountInfo {
+func getAccountDetails(ctx context.Context, account common.Address, itr int) (accountInfo, error) {
 
 	// load all the block details
-	balance, err := client.BalanceAt(context.Background(), account, nil)
+	balance, err := nodeOf(ctx).BalanceAt(ctx, account, nil)
 	if err != nil {
-		log.Fatal(err)
+		return accountInfo{}, nodeError("Reason: `@BalanceAt` failed for "+account.Hex(), err)
 	}
 
 	balanceETH := weiToEther(balance)
 	//fmt.Println(balanceETH)
 	// Here it fetches the latest block for the connected client (i.e., ganache)
-	numBlock, headerByNumberErr := client.HeaderByNumber(context.Background(), nil)
-	kickBack(headerByNumberErr, "Reason:`@HeaderByNumber` failed. Make sure GANACHE runs @ localhost")
-	nonce, _ := client.NonceAt(context.Background(), account, numBlock.Number)
+	numBlock, headerByNumberErr := nodeOf(ctx).HeaderByNumber(ctx, nil)
+	if headerByNumberErr != nil {
+		return accountInfo{}, nodeError("Reason:`@HeaderByNumber` failed. Make sure GANACHE runs @ "+networkOf(ctx).URL, headerByNumberErr)
+	}
+	nonce, _ := nodeOf(ctx).NonceAt(ctx, account, numBlock.Number)
 	//fmt.Println(state)
 	// loading account data for rendering
 	accountData := accountInfo{
@@ -222,32 +296,54 @@ func getAccountDetails(account common.Address, itr int) accountInfo {
 		AccIndex:    itr,
 	}
 
-	return accountData
+	return accountData, nil
 }
 
 /*
 accountsBalance function: fetches the account details and their balance
 */
-func showBalanceInfo(w http.ResponseWriter, r *http.Request) {
+func showBalanceInfo(w http.ResponseWriter, r *http.Request) error {
 	var qss string
 
 	// parsing the request
 	for _, qs := range r.URL.Query() {
 		qss = qs[0]
 	}
+	if !common.IsHexAddress(qss) {
+		return invalidInput("Account address must be a 0x-prefixed 20 byte hex string")
+	}
+
+	accountData, err := loadAccDetails(r.Context(), qss)
+	if err != nil {
+		return err
+	}
 
+	// render
+	tmpl, err := pageTemplate(r.Context(), "checkBalance.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, accountData)
+}
+
+/*
+loadAccDetails function: loads balance and nonce of the given address
+*/
+func loadAccDetails(ctx context.Context, qss string) (accDetails, error) {
 	// load all the block details
-	balance, err := client.BalanceAt(context.Background(), common.BytesToAddress(common.FromHex(qss)), nil)
+	balance, err := nodeOf(ctx).BalanceAt(ctx, common.BytesToAddress(common.FromHex(qss)), nil)
 	if err != nil {
-		log.Fatal(err)
+		return accDetails{}, nodeError("Reason: `@BalanceAt` failed for "+qss, err)
 	}
 
 	balanceETH := weiToEther(balance)
 	//fmt.Println(balanceETH)
 	// Here it fetches the latest block for the connected client (i.e., ganache)
-	numBlock, headerByNumberErr := client.HeaderByNumber(context.Background(), nil)
-	kickBack(headerByNumberErr, "Reason:`@HeaderByNumber` failed. Make sure GANACHE runs @ localhost")
-	nonce, _ := client.NonceAt(context.Background(), common.BytesToAddress(common.FromHex(qss)), numBlock.Number)
+	numBlock, headerByNumberErr := nodeOf(ctx).HeaderByNumber(ctx, nil)
+	if headerByNumberErr != nil {
+		return accDetails{}, nodeError("Reason:`@HeaderByNumber` failed. Make sure GANACHE runs @ "+networkOf(ctx).URL, headerByNumberErr)
+	}
+	nonce, _ := nodeOf(ctx).NonceAt(ctx, common.BytesToAddress(common.FromHex(qss)), numBlock.Number)
 	//fmt.Println(state)
 	// loading account data for rendering
 	accountData := accDetails{
@@ -256,10 +352,7 @@ func showBalanceInfo(w http.ResponseWriter, r *http.Request) {
 		AccTXNCount: nonce,
 	}
 
-	// render
-	tmpl := template.Must(template.ParseFiles("template/checkBalance.html"))
-	tmpl.Execute(w, accountData)
-
+	return accountData, nil
 }
 
 // *********************** txpage **********************************************
@@ -268,117 +361,97 @@ func showBalanceInfo(w http.ResponseWriter, r *http.Request) {
 txPage function: provide the complete transaction details based on the
 block number or block hash.
 */
-func txPage(w http.ResponseWriter, r *http.Request) {
+func txPage(w http.ResponseWriter, r *http.Request) error {
 	/* local variables */
 	var qss string
-	var block *types.Block
-	var listTxDetails []txDetails
-	var err error
-	var toAddress string
-	var execStatus bool
-	var log txLogs
 
 	// parsing the request
 	for _, qs := range r.URL.Query() {
 		qss = qs[0]
 	}
-	bn, strConvErr := strconv.Atoi(qss) // converting string into number to pass in client call
 
-	// has to accept either number or hash, so validating
-	// TODO: what if some other happens, has to validate the err
-	if strConvErr != nil {
-		hash := common.HexToHash(qss)
-		// getting block with hash
-		block, err = client.BlockByHash(context.Background(), hash)
-
-		// check whether block number exists or not
-		if err != nil {
-			execStatus = true
-			log = txLogs{
-				Status:   404,
-				Log:      "Block with given hash is not available in the network",
-				ErrorMsg: err,
-				Host:     "homepage",
-			}
-		} else {
-			execStatus = false
-		}
+	data, err := loadBlockTxPage(r.Context(), qss)
+	if err != nil {
+		return err
+	}
 
-	} else {
+	// render
+	tmpl, err := pageTemplate(r.Context(), "txPage.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
 
-		// getting block with number
-		block, err = client.BlockByNumber(context.Background(), big.NewInt(int64(bn)))
+/*
+loadBlockTxPage function: loads all the transactions of the block identified
+by the given number or hash.
+*/
+func loadBlockTxPage(ctx context.Context, qss string) (txPages, error) {
+	/* local variables */
+	var listTxDetails []txDetails
+	var toAddress string
 
-		// check whether block hash exists or not
-		if err != nil {
-			execStatus = true
-			log = txLogs{
-				Status:   404,
-				Log:      "Block with given number is not available in the network",
-				ErrorMsg: err,
-				Host:     "homepage",
-			}
-		} else {
-			execStatus = false
-		}
+	block, err := fetchBlock(ctx, qss)
+	if err != nil {
+		return txPages{}, err
 	}
 
-	// based on block availability execute
-	if execStatus {
-		// render
-		tmpl := template.Must(template.ParseFiles("template/404.html"))
-		tmpl.Execute(w, log)
+	// getting transaction details
+	var logs []TokenTransferLog
+	var erc721Logs, erc1155Logs []NFTTransferLog
+	eventLogs := []decodedLog{}
 
-	} else {
-		var correctBlockHash *common.Hash = nil // It seems that block.Hash doesn't always returned correct answer; receipt.BlockHash is more reliable.
-		// getting transaction details
-		var logs []TokenTransferLog
-
-		for _, tx := range block.Transactions() {
-			// check for toAddress
-			receipt, _ := client.TransactionReceipt(context.Background(), tx.Hash())
-			if correctBlockHash == nil {
-				correctBlockHash = &receipt.BlockHash
-			}
-			if tx.To() == nil {
-				toAddress = receipt.ContractAddress.Hex() + " [CONTRACT CREATION]"
-			} else {
-				toAddress = tx.To().Hex()
-			}
-
-			signer := types.LatestSignerForChainID(tx.ChainId())
-			sender, _ := signer.Sender(tx)
-			fmt.Println("From inside: ", sender.Hex())
-			valueInWei, valueInEth := getTxValues(tx)
-			dt := txDetails{
-				TxHash:        tx.Hash().Hex(),
-				TxGas:         tx.Gas(),
-				TxGasPrice:    tx.GasPrice().Uint64(),
-				TxNonce:       tx.Nonce(),
-				TxToAddress:   toAddress,
-				TxFromAddress: sender.Hex(),
-				TxData:        hex.EncodeToString(tx.Data()),
-				TxValue:       valueInWei,
-				TxValueInEth:  valueInEth,
-			}
-			// since transaction are multiple, loading it into an array
-			listTxDetails = append(listTxDetails, dt)
-			logs = append(logs, ExtractReceiptLogs(receipt)...)
+	receipts, err := fetchReceipts(ctx, block.Transactions())
+	if err != nil {
+		return txPages{}, nodeError("Couldn't able to fetch the receipts of the block", err)
+	}
+	for i, tx := range block.Transactions() {
+		// check for toAddress
+		receipt := receipts[i]
+		if tx.To() == nil {
+			toAddress = receipt.ContractAddress.Hex() + " [CONTRACT CREATION]"
+		} else {
+			toAddress = tx.To().Hex()
 		}
 
-		// updating final data into struct for rendering
-		data := txPages{
-			BlockNumber:       block.Number(),
-			BlockHash:         correctBlockHash.Hex(),
-			Totaltransactions: 1,
-			TxDetails:         listTxDetails,
-			TokenTransfers:    logs,
+		signer := types.LatestSignerForChainID(tx.ChainId())
+		sender, _ := signer.Sender(tx)
+		valueInWei, valueInEth := getTxValues(tx)
+		dt := txDetails{
+			TxHash:        tx.Hash().Hex(),
+			TxGas:         tx.Gas(),
+			TxGasPrice:    tx.GasPrice().Uint64(),
+			TxNonce:       tx.Nonce(),
+			TxToAddress:   toAddress,
+			TxFromAddress: sender.Hex(),
+			TxData:        hex.EncodeToString(tx.Data()),
+			TxDecoded:     decodeCalldata(networkOf(ctx).URL, tx.To(), tx.Data()),
+			TxValue:       valueInWei,
+			TxValueInEth:  valueInEth,
 		}
+		// since transaction are multiple, loading it into an array
+		listTxDetails = append(listTxDetails, dt)
+		logs = append(logs, ExtractReceiptLogs(ctx, receipt)...)
+		erc721, erc1155 := ExtractNFTTransfers(receipt)
+		erc721Logs = append(erc721Logs, erc721...)
+		erc1155Logs = append(erc1155Logs, erc1155...)
+		eventLogs = append(eventLogs, DecodeReceiptLogs(networkOf(ctx).URL, receipt)...)
+	}
 
-		// render
-		tmpl := template.Must(template.ParseFiles("template/txPage.html"))
-		tmpl.Execute(w, data)
+	// updating final data into struct for rendering
+	data := txPages{
+		BlockNumber:       block.Number(),
+		BlockHash:         block.Hash().Hex(),
+		Totaltransactions: block.transactionCount(),
+		TxDetails:         listTxDetails,
+		TokenTransfers:    logs,
+		ERC721Transfers:   erc721Logs,
+		ERC1155Transfers:  erc1155Logs,
+		EventLogs:         eventLogs,
 	}
+
+	return data, nil
 }
 
 func getTxValues(tx *types.Transaction) (*big.Int, *big.Float) {
@@ -391,60 +464,71 @@ func getTxValues(tx *types.Transaction) (*big.Int, *big.Float) {
 //
 //	txDetailsPage function: provide the complete transaction details based on the
 //	transaction hash.
-func txDetailsPage(w http.ResponseWriter, r *http.Request) {
+func txDetailsPage(w http.ResponseWriter, r *http.Request) error {
 	/* local variables */
 	var qss string
+
+	// parsing the request
+	for _, qs := range r.URL.Query() {
+		qss = qs[0]
+	}
+
+	data, err := loadTxDetails(r.Context(), qss)
+	if err != nil {
+		return err
+	}
+
+	// Render the updated template
+	tmpl, err := pageTemplate(r.Context(), "txPage.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+/*
+loadTxDetails function: loads the transaction and its receipt for the given
+transaction hash.
+*/
+func loadTxDetails(ctx context.Context, qss string) (txPages, error) {
+	/* local variables */
 	var tx *types.Transaction
 	var listTxDetails []txDetails
 	var err error
 	var toAddress string
 
-	var execStatus bool
-	var log txLogs
 	var receipt *types.Receipt
 	var receiptStatus string
 
-	// parsing the request
-	for _, qs := range r.URL.Query() {
-		qss = qs[0]
+	// only a hash identifies a transaction
+	if !hashPattern.MatchString(qss) {
+		return txPages{}, invalidInput("Txn hash must be a 0x-prefixed 32 byte hex string")
 	}
-	_, strConvErr := strconv.Atoi(qss) // converting string into number to pass in client call
 
-	// has to accept either number or hash, so validating
-	if strConvErr != nil {
-		hash := common.HexToHash(qss)
+	hash := common.HexToHash(qss)
 
-		// getting txn with hash
-		tx, _, err = client.TransactionByHash(context.Background(), hash)
-		receipt, _ = client.TransactionReceipt(context.Background(), hash)
+	// getting txn with hash
+	tx, err = fetchTransaction(ctx, hash)
 
-		if receipt != nil && receipt.Status == uint64(1) {
-			receiptStatus = "SUCCESSFUL"
-		} else {
-			receiptStatus = "FAILED"
-		}
-
-		fmt.Println("Transaction Status : ", receiptStatus)
-
-		// check whether block number exists or not
-		if err != nil {
-			execStatus = true
-			log = txLogs{
-				Status:   404,
-				Log:      "Txn with given hash is not available in the network",
-				ErrorMsg: err,
-				Host:     "homepage",
-			}
-		} else {
-			execStatus = false
-		}
+	// if transaction does not exist, return 404
+	if errors.Is(err, types.ErrTxTypeNotSupported) {
+		return txPages{}, invalidInput("Txn " + hash.Hex() + " is of a type this explorer cannot read, e.g. a blob transaction")
+	}
+	if err != nil {
+		return txPages{}, nodeError("Txn with given hash is not available in the network", err)
+	}
+	receipt, err = fetchReceipt(ctx, hash)
+	if errors.Is(err, ethereum.NotFound) {
+		return txPages{}, notFound("Txn with given hash is still pending, it has no receipt yet", nil)
+	}
+	if err != nil {
+		return txPages{}, nodeError("Reason: `eth_getTransactionReceipt` failed. Couldn't able to fetch the receipt of the txn", err)
 	}
 
-	// if transaction does not exist, return 404
-	if execStatus || err != nil {
-		tmpl := template.Must(template.ParseFiles("template/404.html"))
-		tmpl.Execute(w, log)
-		return
+	if receipt.Status == types.ReceiptStatusSuccessful {
+		receiptStatus = "SUCCESSFUL"
+	} else {
+		receiptStatus = "FAILED"
 	}
 
 	// Getting transaction details
@@ -468,6 +552,7 @@ func txDetailsPage(w http.ResponseWriter, r *http.Request) {
 		TxToAddress:   toAddress,
 		TxFromAddress: sender.Hex(),
 		TxData:        hex.EncodeToString(tx.Data()),
+		TxDecoded:     decodeCalldata(networkOf(ctx).URL, tx.To(), tx.Data()),
 		TxValue:       valueInWei,
 		TxValueInEth:  valueInEth,
 	}
@@ -475,51 +560,34 @@ func txDetailsPage(w http.ResponseWriter, r *http.Request) {
 	listTxDetails = append(listTxDetails, dt)
 
 	// Extract token transfers from logs
+	erc721Logs, erc1155Logs := ExtractNFTTransfers(receipt)
 
 	// Updating final data into struct for rendering
 	data := txPages{
 		BlockNumber:       receipt.BlockNumber,
 		BlockHash:         receipt.BlockHash.Hex(),
-		Totaltransactions: 1,
+		Totaltransactions: len(listTxDetails),
 		TransactionStatus: receiptStatus,
+		Failure:           explainFailure(ctx, tx, receipt),
 		TxDetails:         listTxDetails,
-		TokenTransfers:    ExtractReceiptLogs(receipt), // Include token transfers in data
+		TokenTransfers:    ExtractReceiptLogs(ctx, receipt), // Include token transfers in data
+		ERC721Transfers:   erc721Logs,
+		ERC1155Transfers:  erc1155Logs,
+		EventLogs:         DecodeReceiptLogs(networkOf(ctx).URL, receipt),
 	}
+	data.CallTrace, data.TraceStatus = loadCallTrace(ctx, tx)
 
-	// Render the updated template
-	tmpl := template.Must(template.ParseFiles("template/txPage.html"))
-	tmpl.Execute(w, data)
+	return data, nil
 }
 
 // *********************** txDetails *******************************************
 
-// *********************** On Account of failure *******************************
-
-/*
-kickBack function: kickback to 404 if any invalid request or failure happens
-*/
-func kickBackErr(w http.ResponseWriter, r *http.Request) {
-	tmpl := template.Must(template.ParseFiles("template/404.html"))
-	tmpl.Execute(w, nil)
-}
-func kickBack(err error, msg string) {
-	if err != nil {
-		fmt.Println("/******** ERROR ********************************************/")
-		fmt.Printf("Error: %v", err)
-		fmt.Printf("Reason: %v", msg)
-		panic(err)
-	}
-}
-
 // *********************** homepage ********************************************
 
 /*
 homePage function: serves the content for the main home page.
 */
-func homePage(w http.ResponseWriter, r *http.Request) {
-	/* local variables */
-	const BLOCKS_IN_PAGE = 10
-
+func homePage(w http.ResponseWriter, r *http.Request) error {
 	params := mux.Vars(r)
 	page := int64(0)
 
@@ -529,101 +597,124 @@ func homePage(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var _blockdetails []blockInfo     // to hold the blockNumber
-	var _accountDetails []accountInfo // to hold the blockNumber
+	// old links to a host only fill in the welcome form, which posts it to
+	// /networks; a GET must not make the explorer dial anything
+	if host := r.URL.Query().Get("ganachehost"); host != "" {
+		return renderWelcome(w, r, host)
+	}
 
-	var clientErr error
+	data, err := loadSysInfo(r.Context(), page)
+	if err != nil {
+		return err
+	}
 
-	// parsing the request
-	for _, qs := range r.URL.Query() {
-		NetworkHost = qs[0]
+	// mux render
+	tmpl, err := pageTemplate(r.Context(), "index.html")
+	if err != nil {
+		return err
 	}
+	return tmpl.Execute(w, data)
+}
 
-	// updating the client
-	client, clientErr = ethclient.Dial(NetworkHost)
+/*
+loadSysInfo function: loads the network statistics, the given page of recent
+blocks and the node accounts.
+*/
+func loadSysInfo(ctx context.Context, page int64) (sysInfo, error) {
+	/* local variables */
+	blocksInPage := int64(config.Pages.Blocks)
 
-	if clientErr != nil {
-		log := txLogs{
-			Status:   404,
-			Log:      "Host provided is invalid. Client Error",
-			ErrorMsg: clientErr,
-		}
-		tmpl := template.Must(template.ParseFiles("template/404.html"))
-		tmpl.Execute(w, log)
-	} else {
-		// Here it fetches the latest block for the connected client (i.e., ganache)
-		numBlock, headerByNumberErr := client.HeaderByNumber(context.Background(), nil)
-		kickBack(headerByNumberErr, "Reason:`@HeaderByNumber` failed. Make sure GANACHE runs @ localhost")
-		// Here it fetches the NetworkID for the connected client (i.e., ganache)
-		networkID, networkIDErr := client.NetworkID(context.Background())
-		kickBack(networkIDErr, "Reason: `@NetworkID` failed. Make sure GANACHE runs @ localhost")
-		// Here it fetches the pending transaction for the connected client (i.e., ganache)
-		pendingTxCount, _ := client.PendingTransactionCount(context.Background())
-		// Here it fetches the suggested gas price for the connected client (i.e., ganache)
-		suggestedGasPrice, suggestGasPriceError := client.SuggestGasPrice(context.Background())
-		kickBack(suggestGasPriceError, "Reason: `@SuggestGasPrice` failed. Couldn't able to fetch Suggested Gas Price")
-
-		// Here it fetches only the lasted 5 block for the home page
-		pageFirstBlock := numBlock.Number.Int64() - BLOCKS_IN_PAGE*page
-		if pageFirstBlock < 0 {
-			pageFirstBlock = BLOCKS_IN_PAGE
-		}
-		pageLastBlock := (pageFirstBlock - BLOCKS_IN_PAGE)
-		if pageFirstBlock < 0 {
-			pageFirstBlock = 0
-		}
+	var _blockdetails []blockInfo     // to hold the blockNumber
+	var _accountDetails []accountInfo // to hold the blockNumber
+	var liveEventID uint64            // events from now on may not be on the page
+	if config.Features.Live {
+		liveEventID = networkOf(ctx).live().latest()
+	}
 
-		for x := pageFirstBlock; x > pageLastBlock; x-- {
-			if x < 1 {
-				// Todo : break here to overcome negativity
-				break
-			} else {
-				// load all the block details
-				_blockdetails = append(_blockdetails, blockPage(w, big.NewInt(x)))
-			}
-		}
+	// Here it fetches the latest block for the connected client (i.e., ganache)
+	numBlock, headerByNumberErr := nodeOf(ctx).HeaderByNumber(ctx, nil)
+	if headerByNumberErr != nil {
+		return sysInfo{}, nodeError("Reason:`@HeaderByNumber` failed. Make sure GANACHE runs @ "+networkOf(ctx).URL, headerByNumberErr)
+	}
+	// Here it fetches the NetworkID for the connected client (i.e., ganache)
+	networkID, networkIDErr := nodeOf(ctx).NetworkID(ctx)
+	if networkIDErr != nil {
+		return sysInfo{}, nodeError("Reason: `@NetworkID` failed. Make sure GANACHE runs @ "+networkOf(ctx).URL, networkIDErr)
+	}
+	// Here it fetches the pending transaction for the connected client (i.e., ganache)
+	pendingTxCount, _ := nodeOf(ctx).PendingTransactionCount(ctx)
+	// Here it fetches the suggested gas price for the connected client (i.e., ganache)
+	suggestedGasPrice, suggestGasPriceError := nodeOf(ctx).SuggestGasPrice(ctx)
+	if suggestGasPriceError != nil {
+		return sysInfo{}, nodeError("Reason: `@SuggestGasPrice` failed. Couldn't able to fetch Suggested Gas Price", suggestGasPriceError)
+	}
 
-		clientGanache := newClient(NetworkHost)
-		var accounts []string
+	// Here it fetches only the lasted 5 block for the home page
+	pageFirstBlock := numBlock.Number.Int64() - blocksInPage*page
+	if pageFirstBlock < 0 {
+		pageFirstBlock = blocksInPage
+	}
+	pageLastBlock := (pageFirstBlock - blocksInPage)
+	if pageFirstBlock < 0 {
+		pageFirstBlock = 0
+	}
 
-		err := clientGanache.call("eth_accounts", &accounts)
+	// load the block details of the page concurrently, in page order
+	var pageBlocks []int64
+	for x := pageFirstBlock; x > pageLastBlock && x >= 1; x-- {
+		pageBlocks = append(pageBlocks, x)
+	}
+	_blockdetails = make([]blockInfo, len(pageBlocks))
+	err := runConcurrently(len(pageBlocks), RPC_WORKERS, func(i int) error {
+		blockData, err := blockPage(ctx, big.NewInt(pageBlocks[i]))
+		_blockdetails[i] = blockData
+		return err
+	})
+	if err != nil {
+		return sysInfo{}, err
+	}
 
-		if err != nil {
-			log.Fatal(err)
-		}
-		//fmt.Println(accounts)
-		// getting account details
-		itr := 0
-		for _, acc := range accounts {
-			// check for toAddress
-			account := common.HexToAddress(acc)
-			_accountDetails = append(_accountDetails, getAccountDetails(account, itr))
-			itr += 1
-		}
-		// data: values to be rendered
-		nextPage := page + 1
-		if pageLastBlock == 0 {
-			nextPage = page
-		}
-		prevPage := page - 1
-		if prevPage < 0 {
-			prevPage = 0
-		}
-		data := sysInfo{
-			NumBlock:                numBlock.Number.String(),
-			NetworkID:               networkID,
-			PendingTransactionCount: pendingTxCount,
-			SuggestedGasPrice:       suggestedGasPrice,
-			BlockDetails:            _blockdetails,
-			AccountDetails:          _accountDetails,
-			NextPage:                nextPage,
-			PrevPage:                prevPage,
-		}
+	clientGanache := networkOf(ctx).rpc
+	var accounts []string
 
-		// mux render
-		tmpl := template.Must(template.ParseFiles("template/index.html"))
-		tmpl.Execute(w, data)
+	err = clientGanache.call(ctx, "eth_accounts", &accounts)
+
+	if err != nil {
+		return sysInfo{}, nodeError("Reason: `eth_accounts` failed. Couldn't able to list the node accounts", err)
+	}
+	//fmt.Println(accounts)
+	// getting account details, concurrently like the blocks
+	_accountDetails = make([]accountInfo, len(accounts))
+	err = runConcurrently(len(accounts), RPC_WORKERS, func(itr int) error {
+		accountData, err := getAccountDetails(ctx, common.HexToAddress(accounts[itr]), itr)
+		_accountDetails[itr] = accountData
+		return err
+	})
+	if err != nil {
+		return sysInfo{}, err
+	}
+	// data: values to be rendered
+	nextPage := page + 1
+	if pageLastBlock == 0 {
+		nextPage = page
 	}
+	prevPage := page - 1
+	if prevPage < 0 {
+		prevPage = 0
+	}
+	data := sysInfo{
+		NumBlock:                numBlock.Number.String(),
+		NetworkID:               networkID,
+		PendingTransactionCount: pendingTxCount,
+		SuggestedGasPrice:       suggestedGasPrice,
+		BlockDetails:            _blockdetails,
+		AccountDetails:          _accountDetails,
+		NextPage:                nextPage,
+		PrevPage:                prevPage,
+		LiveEventID:             liveEventID,
+	}
+
+	return data, nil
 }
 
 // *********************** welcome page ****************************************
@@ -631,9 +722,53 @@ func homePage(w http.ResponseWriter, r *http.Request) {
 /*
 welcomePage function: serves the welcome page.
 */
-func welcomePage(w http.ResponseWriter, r *http.Request) {
-	tmpl := template.Must(template.ParseFiles("template/welcome.html"))
-	tmpl.Execute(w, nil)
+func welcomePage(w http.ResponseWriter, r *http.Request) error {
+	return renderWelcome(w, r, "")
+}
+
+// renderWelcome renders the welcome page with host filled into its form
+func renderWelcome(w http.ResponseWriter, r *http.Request, host string) error {
+	tmpl, err := pageTemplate(r.Context(), "welcome.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, host)
+}
+
+/*
+registerRoutes function: mounts the pages and the api of one network on the
+given router
+*/
+func registerRoutes(router *mux.Router) {
+	// controller
+	router.Handle("/homepage", appHandler(homePage))
+	router.Handle("/homepage/{page:[a-zA-Z0-9]*}", appHandler(homePage))
+	router.Handle("/txinfo", appHandler(txDetailsPage))
+	router.Handle("/txpage", appHandler(txPage))
+	router.Handle("/txdebug", appHandler(txDebugPageHandler))
+	router.Handle("/blockdetails", appHandler(blockInDetails))
+	router.Handle("/accInfo", appHandler(showBalanceInfo))
+	router.Handle("/address/{address}", appHandler(addressInfoPage))
+	router.Handle("/tokens", appHandler(tokensPage))
+	router.Handle("/pending", appHandler(pendingPage))
+	router.Handle("/search", appHandler(searchHandler)).Methods(http.MethodGet)
+	if config.Features.DevChain {
+		router.Handle("/admin", appHandler(devChainHandler)).Methods(http.MethodGet, http.MethodPost)
+	}
+	if config.Features.Send {
+		router.Handle("/send", appHandler(sendTxHandler)).Methods(http.MethodGet, http.MethodPost)
+	}
+	if config.Features.Faucet {
+		router.Handle("/faucet", appHandler(faucetHandler)).Methods(http.MethodPost)
+	}
+	router.Handle("/contracts", appHandler(contractsHandler)).Methods(http.MethodGet, http.MethodPost)
+	router.Handle("/networks", appHandler(networksHandler)).Methods(http.MethodGet, http.MethodPost)
+	if config.Features.Live {
+		router.Handle("/events", appHandler(liveEvents))
+	}
+
+	// versioned JSON API mirroring the pages above
+	registerAPIRoutes(router)
 }
 
 // *********************** main ************************************************
@@ -643,37 +778,127 @@ func welcomePage(w http.ResponseWriter, r *http.Request) {
 */
 func main() {
 
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	cfg.apply()
+	if cfg.File != "" {
+		log.Println("config: read", cfg.File)
+	}
+	local, err := networks.add(DEFAULT_NETWORK, cfg.Node.URL)
+	if err != nil {
+		log.Fatalf("-node: %v", err)
+	}
+	local.faucetFunder = cfg.Faucet.Funder
+	for _, name := range cfg.Networks.names() {
+		if _, err := networks.add(name, cfg.Networks[name]); err != nil {
+			log.Fatalf("-networks: %v", err)
+		}
+	}
+
 	fmt.Println("!!!!INITIALIZING SERVER!!!!")
 	// mux router
 	gorilla := mux.NewRouter()
 
 	// network client activation
-	client, _ = ethclient.Dial(NetworkHost)
+	local.connect()
+
+	// block index: follows the -node network in the background; pages fall
+	// back to the node when it is missing
+	if !cfg.Features.Index {
+		log.Println("index: disabled")
+	} else if idx, err := openIndex(cfg.Paths.Index, local.URL); err != nil {
+		log.Println("index: running without block index,", err)
+	} else {
+		local.index = idx
+		go idx.run()
+	}
+
+	// live feed: pushes new blocks and pending transactions to open pages
+	if cfg.Features.Live {
+		local.live()
+	}
+
+	// build artifacts: label the contracts they deployed on the chain
+	if artifactsDir := cfg.Paths.Artifacts; artifactsDir != "" {
+		loaded, err := loadArtifacts(artifactsDir)
+		if err != nil {
+			log.Println("artifacts: couldn't load", artifactsDir+",", err)
+		}
+		log.Printf("artifacts: loaded %d build artifacts from %s", len(loaded), artifactsDir)
+		artifacts.artifacts = loaded
+		go artifacts.run(local)
+	}
 
-	// for the static file handling, all the assets files will be loaded into the static folder
-	staticFileHandler := http.FileServer(http.Dir("static"))
+	// signature database: decodes calls and logs of contracts without an abi
+	if _, err := signatures.load(strings.NewReader(bundledSignatures), "bundled signatures"); err != nil {
+		log.Println("signatures:", err)
+	}
+	if signaturesFile := cfg.Paths.Signatures; signaturesFile != "" {
+		added, err := importSignatures(signaturesFile)
+		if err != nil {
+			log.Println("signatures: import stopped,", err)
+		}
+		log.Printf("signatures: imported %d signatures from %s", added, signaturesFile)
+	}
+
+	// contract abis registered through /contracts, used to decode calldata and logs
+	if err := contractABIs.load(local.URL); err != nil {
+		log.Println("abi: couldn't load the registered ABIs,", err)
+	}
+
+	// page templates: parsed once, from the binary or -templates
+	if err := pages.load(); err != nil {
+		log.Fatalf("templates: %v", err)
+	}
+
+	// for the static file handling, all the assets files are served from the binary or -static
+	staticDir, err := staticFiles()
+	if err != nil {
+		log.Fatalf("static: %v", err)
+	}
+	staticFileHandler := http.FileServer(staticDir)
 
 	// routes the all the static accessing url to the static folder
 	gorilla.PathPrefix("/static/").Handler(http.StripPrefix("/static/", staticFileHandler))
 
-	// controller
-	gorilla.HandleFunc("/homepage", homePage)
-	gorilla.HandleFunc("/homepage/{page:[a-zA-Z0-9]*}", homePage)
-	gorilla.HandleFunc("/txinfo", txDetailsPage)
-	gorilla.HandleFunc("/txpage", txPage)
-	gorilla.HandleFunc("/blockdetails", blockInDetails)
-	gorilla.HandleFunc("/accInfo", showBalanceInfo)
-	gorilla.HandleFunc("/", welcomePage)
+	// a failing handler renders an error page instead of crashing the server
+	gorilla.Use(recoverPanics)
+	// node calls of a request end with it, before the server stops writing
+	gorilla.Use(withRequestTimeout)
+	// forms and api calls that change state only come from the explorer's own pages
+	gorilla.Use(checkOrigin)
+
+	// the pages of every network under /n/<name>, the default network also
+	// without the prefix
+	networkRoutes := gorilla.PathPrefix(NETWORK_PATH_PREFIX).Subrouter()
+	networkRoutes.Use(withNetwork)
+	registerRoutes(networkRoutes)
+	defaultRoutes := gorilla.NewRoute().Subrouter()
+	defaultRoutes.Use(withNetwork)
+	registerRoutes(defaultRoutes)
+
+	gorilla.Handle("/", appHandler(welcomePage))
+
+	// unknown pages get the same error page as a missing block
+	gorilla.NotFoundHandler = recoverPanics(appHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return notFound("Page "+r.URL.Path+" does not exist", nil)
+	}))
 
 	// http server
 	// Note: Here gorilla is like passing our own server handler into net/http, by default its false
 	srv := &http.Server{
 		Handler: gorilla,
-		Addr:    "0.0.0.0:5051",
+		Addr:    cfg.Listen,
 		// Good practice: enforce timeouts for servers you create!
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+		WriteTimeout: cfg.WriteTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+	}
+	if cfg.TLS.Cert != "" {
+		fmt.Println("!!!! SERVER STARTED @ https://" + cfg.Listen + " !!!!")
+		log.Fatal(srv.ListenAndServeTLS(cfg.TLS.Cert, cfg.TLS.Key))
 	}
-	fmt.Println("!!!! SERVER STARTED @ :5051 !!!!")
+	fmt.Println("!!!! SERVER STARTED @ " + cfg.Listen + " !!!!")
 	log.Fatal(srv.ListenAndServe())
 }
@@ -2,38 +2,56 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	RPC_BATCH_SIZE = 100 // requests sent in one JSON-RPC batch
+	RPC_WORKERS    = 4   // batches in flight at once
 )
 
+// id of the last request sent by any client, responses are matched on it;
+// shared since clients of one ws or ipc endpoint share its connection
+var lastRequestID uint64
+
 type EthRPC struct {
-	url    string
-	client httpClient
-	log    logger
-	Debug  bool
+	url       string
+	client    httpClient
+	log       logger
+	Debug     bool
+	stream    *streamTransport // set for ws, wss and ipc endpoints, which are not posted to
+	batchSize int
+	workers   int
 }
 type logger interface {
 	Println(v ...interface{})
 }
 
 type httpClient interface {
-	Post(url string, contentType string, body io.Reader) (*http.Response, error)
+	Do(req *http.Request) (*http.Response, error)
 }
 
 type ethRequest struct {
-	ID      int           `json:"id"`
+	ID      uint64        `json:"id"`
 	JSONRPC string        `json:"jsonrpc"`
 	Method  string        `json:"method"`
 	Params  []interface{} `json:"params"`
 }
 
 type ethResponse struct {
-	ID      int             `json:"id"`
+	ID      uint64          `json:"id"`
 	JSONRPC string          `json:"jsonrpc"`
 	Result  json.RawMessage `json:"result"`
 	Error   *EthError       `json:"error"`
@@ -49,8 +67,8 @@ func (err EthError) Error() string {
 	return fmt.Sprintf("Error %d (%s)", err.Code, err.Message)
 }
 
-func (rpc *EthRPC) call(method string, target interface{}, params ...interface{}) error {
-	result, err := rpc.Call(method, params...)
+func (rpc *EthRPC) call(ctx context.Context, method string, target interface{}, params ...interface{}) error {
+	result, err := rpc.CallContext(ctx, method, params...)
 	if err != nil {
 		return err
 	}
@@ -64,19 +82,63 @@ func (rpc *EthRPC) call(method string, target interface{}, params ...interface{}
 
 // Call returns raw response of method call
 func (rpc *EthRPC) Call(method string, params ...interface{}) (json.RawMessage, error) {
-	request := ethRequest{
-		ID:      1,
+	return rpc.CallContext(context.Background(), method, params...)
+}
+
+// CallContext returns raw response of method call, abandoned when ctx ends
+func (rpc *EthRPC) CallContext(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+	request := rpc.request(method, params)
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := rpc.post(ctx, method, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := new(ethResponse)
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, *resp.Error
+	}
+
+	return resp.Result, nil
+
+}
+
+func (rpc *EthRPC) request(method string, params []interface{}) ethRequest {
+	if params == nil {
+		params = []interface{}{} // some nodes reject "params": null
+	}
+	return ethRequest{
+		ID:      atomic.AddUint64(&lastRequestID, 1),
 		JSONRPC: "2.0",
 		Method:  method,
 		Params:  params,
 	}
+}
 
-	body, err := json.Marshal(request)
+// post sends one request or batch body and returns the raw response body
+func (rpc *EthRPC) post(ctx context.Context, method string, body []byte) ([]byte, error) {
+	if rpc.stream != nil {
+		data, err := rpc.stream.roundTrip(ctx, body)
+		if err == nil && rpc.Debug {
+			rpc.log.Println(fmt.Sprintf("%s\nRequest: %s\nResponse: %s\n", method, body, data))
+		}
+		return data, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, rpc.url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
-
-	response, err := rpc.client.Post(rpc.url, "application/json", bytes.NewBuffer(body))
+	request.Header.Set("Content-Type", "application/json")
+	response, err := rpc.client.Do(request)
 	if response != nil {
 		defer response.Body.Close()
 	}
@@ -92,26 +154,152 @@ func (rpc *EthRPC) Call(method string, params ...interface{}) (json.RawMessage,
 	if rpc.Debug {
 		rpc.log.Println(fmt.Sprintf("%s\nRequest: %s\nResponse: %s\n", method, body, data))
 	}
+	return data, nil
+}
 
-	resp := new(ethResponse)
-	if err := json.Unmarshal(data, resp); err != nil {
-		return nil, err
+// *********************** batches *********************************************
+
+// BatchElem is one call of a batch; Error is set when that call alone failed
+type BatchElem struct {
+	Method string
+	Params []interface{}
+	Result interface{} // unmarshalled into when not nil
+	Error  error
+}
+
+/*
+BatchCall function: sends every element in one JSON-RPC batch array and
+matches the responses back by id, since nodes may answer in any order. The
+returned error is for the round trip; each element carries its own error
+*/
+func (rpc *EthRPC) BatchCall(ctx context.Context, batch []BatchElem) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	requests := make([]ethRequest, len(batch))
+	byID := make(map[uint64]*BatchElem, len(batch))
+	for i := range batch {
+		requests[i] = rpc.request(batch[i].Method, batch[i].Params)
+		byID[requests[i].ID] = &batch[i]
 	}
 
-	if resp.Error != nil {
-		return nil, *resp.Error
+	body, err := json.Marshal(requests)
+	if err != nil {
+		return err
+	}
+	data, err := rpc.post(ctx, fmt.Sprintf("batch of %d", len(batch)), body)
+	if err != nil {
+		return err
 	}
 
-	return resp.Result, nil
+	var responses []ethResponse
+	if err := json.Unmarshal(data, &responses); err != nil {
+		// a node rejecting the whole batch answers with a single error object
+		single := new(ethResponse)
+		if json.Unmarshal(data, single) == nil && single.Error != nil {
+			return *single.Error
+		}
+		return err
+	}
+	for _, resp := range responses {
+		elem, ok := byID[resp.ID]
+		if !ok {
+			continue
+		}
+		delete(byID, resp.ID)
+		switch {
+		case resp.Error != nil:
+			elem.Error = *resp.Error
+		case elem.Result != nil:
+			elem.Error = json.Unmarshal(resp.Result, elem.Result)
+		}
+	}
+	for _, elem := range byID {
+		elem.Error = errors.New("no response to " + elem.Method + " in the batch")
+	}
+	return nil
+}
 
+/*
+BatchCallParallel function: splits a large batch into batches of batchSize
+and sends them with at most workers in flight
+*/
+func (rpc *EthRPC) BatchCallParallel(ctx context.Context, batch []BatchElem) error {
+	chunks := (len(batch) + rpc.batchSize - 1) / rpc.batchSize
+	return runConcurrently(chunks, rpc.workers, func(i int) error {
+		end := (i + 1) * rpc.batchSize
+		if end > len(batch) {
+			end = len(batch)
+		}
+		return rpc.BatchCall(ctx, batch[i*rpc.batchSize:end])
+	})
+}
+
+/*
+runConcurrently function: a bounded worker pool running task(0..n-1) on at
+most workers goroutines; returns the first error
+*/
+func runConcurrently(n, workers int, task func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := task(i); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	close(errs)
+	return <-errs // nil when the channel is empty
+}
+
+/*
+TransactionReceipts function: fetches the receipts of every hash in as few
+round trips as the batch size allows; a missing receipt (pending transaction)
+fails the whole call
+*/
+func (rpc *EthRPC) TransactionReceipts(ctx context.Context, hashes []common.Hash) ([]*types.Receipt, error) {
+	receipts := make([]*types.Receipt, len(hashes))
+	batch := make([]BatchElem, len(hashes))
+	for i, hash := range hashes {
+		batch[i] = BatchElem{Method: "eth_getTransactionReceipt", Params: []interface{}{hash}, Result: &receipts[i]}
+	}
+	if err := rpc.BatchCallParallel(ctx, batch); err != nil {
+		return nil, err
+	}
+	for i, elem := range batch {
+		if elem.Error != nil {
+			return nil, elem.Error
+		}
+		if receipts[i] == nil {
+			return nil, errors.New("no receipt for " + hashes[i].Hex())
+		}
+	}
+	return receipts, nil
 }
 
 // New create new rpc client with given url
 func newClient(url string, options ...func(rpc *EthRPC)) *EthRPC {
 	rpc := &EthRPC{
-		url:    url,
-		client: http.DefaultClient,
-		log:    log.New(os.Stderr, "", log.LstdFlags),
+		url:       url,
+		client:    nodeHTTPClient,
+		log:       log.New(os.Stderr, "", log.LstdFlags),
+		batchSize: RPC_BATCH_SIZE,
+		workers:   RPC_WORKERS,
+		stream:    streamTransportFor(url),
 	}
 	for _, option := range options {
 		option(rpc)
@@ -119,18 +307,3 @@ func newClient(url string, options ...func(rpc *EthRPC)) *EthRPC {
 
 	return rpc
 }
-
-/*
-func main() {
-	client := newClient("http://18.193.129.167:8545")
-
-	var accounts []string
-
-	_ = accounts
-	err := client.call("eth_accounts", &accounts)
-
-	if err != nil {
-		log.Fatal(err)
-	}
-	fmt.Println(accounts)
-}*/
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/*
+batchNode function: a node answering every call of a batch with its first
+param, or with an error for the method "fail"; answer rearranges the
+responses the way a node may
+*/
+func batchNode(t *testing.T, answer func([]ethResponse) interface{}) *httptest.Server {
+	t.Helper()
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []ethRequest
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			t.Errorf("node got no batch: %v", err)
+			return
+		}
+		responses := make([]ethResponse, len(requests))
+		for i, req := range requests {
+			responses[i] = ethResponse{ID: req.ID, JSONRPC: "2.0"}
+			if req.Method == "fail" {
+				responses[i].Error = &EthError{Code: -32000, Message: "failed " + fmt.Sprint(req.Params[0])}
+				continue
+			}
+			responses[i].Result, _ = json.Marshal(req.Params[0])
+		}
+		json.NewEncoder(w).Encode(answer(responses))
+	}))
+	t.Cleanup(node.Close)
+	return node
+}
+
+func TestBatchCallMatchesByID(t *testing.T) {
+	asSent := func(responses []ethResponse) interface{} { return responses }
+	tests := []struct {
+		name    string
+		methods []string
+		answer  func([]ethResponse) interface{}
+		want    []string // result of each element, or its error
+		err     string   // error of the whole batch
+	}{
+		{
+			name:    "in order",
+			methods: []string{"a", "a", "a"},
+			answer:  asSent,
+			want:    []string{"0", "1", "2"},
+		},
+		{
+			name:    "reversed",
+			methods: []string{"a", "a", "a", "a"},
+			answer: func(responses []ethResponse) interface{} {
+				for i, j := 0, len(responses)-1; i < j; i, j = i+1, j-1 {
+					responses[i], responses[j] = responses[j], responses[i]
+				}
+				return responses
+			},
+			want: []string{"0", "1", "2", "3"},
+		},
+		{
+			name:    "one call fails",
+			methods: []string{"a", "fail", "a"},
+			answer:  asSent,
+			want:    []string{"0", "Error -32000 (failed 1)", "2"},
+		},
+		{
+			name:    "response missing",
+			methods: []string{"a", "a", "a"},
+			answer:  func(responses []ethResponse) interface{} { return responses[:2] },
+			want:    []string{"0", "1", "no response to a in the batch"},
+		},
+		{
+			name:    "unknown and repeated ids ignored",
+			methods: []string{"a", "a"},
+			answer: func(responses []ethResponse) interface{} {
+				stray := ethResponse{ID: 0, JSONRPC: "2.0", Result: json.RawMessage(`"stray"`)}
+				repeat := responses[0]
+				repeat.Result = json.RawMessage(`"repeat"`)
+				return append([]ethResponse{stray}, append(responses, repeat)...)
+			},
+			want: []string{"0", "1"},
+		},
+		{
+			name:    "whole batch rejected",
+			methods: []string{"a", "a"},
+			answer: func([]ethResponse) interface{} {
+				return ethResponse{JSONRPC: "2.0", Error: &EthError{Code: -32600, Message: "batch too large"}}
+			},
+			err: "batch too large",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := batchNode(t, tt.answer)
+			rpc := newClient(node.URL, func(rpc *EthRPC) { rpc.client = node.Client() })
+
+			results := make([]string, len(tt.methods))
+			batch := make([]BatchElem, len(tt.methods))
+			for i, method := range tt.methods {
+				batch[i] = BatchElem{Method: method, Params: []interface{}{fmt.Sprint(i)}, Result: &results[i]}
+			}
+			err := rpc.BatchCall(context.Background(), batch)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("BatchCall error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BatchCall: %v", err)
+			}
+			for i, elem := range batch {
+				got := results[i]
+				if elem.Error != nil {
+					got = elem.Error.Error()
+				}
+				if got != tt.want[i] {
+					t.Errorf("element %d = %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBatchCallParallelSplits(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		batchSize int
+		workers   int
+		batches   int
+	}{
+		{"smaller than a batch", 3, 10, 4, 1},
+		{"exact batches", 6, 2, 2, 3},
+		{"last batch short", 7, 3, 4, 3},
+		{"single worker", 5, 1, 1, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches := make(chan int, tt.size)
+			node := batchNode(t, func(responses []ethResponse) interface{} {
+				batches <- len(responses)
+				return responses
+			})
+			rpc := newClient(node.URL, func(rpc *EthRPC) {
+				rpc.client, rpc.batchSize, rpc.workers = node.Client(), tt.batchSize, tt.workers
+			})
+
+			results := make([]string, tt.size)
+			batch := make([]BatchElem, tt.size)
+			for i := range batch {
+				batch[i] = BatchElem{Method: "a", Params: []interface{}{fmt.Sprint(i)}, Result: &results[i]}
+			}
+			if err := rpc.BatchCallParallel(context.Background(), batch); err != nil {
+				t.Fatalf("BatchCallParallel: %v", err)
+			}
+			close(batches)
+			if len(batches) != tt.batches {
+				t.Errorf("node got %d batches, want %d", len(batches), tt.batches)
+			}
+			for size := range batches {
+				if size > tt.batchSize {
+					t.Errorf("node got a batch of %d, more than %d", size, tt.batchSize)
+				}
+			}
+			for i, elem := range batch {
+				if elem.Error != nil || results[i] != fmt.Sprint(i) {
+					t.Errorf("element %d = %q (%v), want %q", i, results[i], elem.Error, fmt.Sprint(i))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	bolt "go.etcd.io/bbolt"
+)
+
+// *********************** search **********************************************
+
+// kinds of search matches
+const (
+	SEARCH_BLOCK       = "block"
+	SEARCH_TRANSACTION = "transaction"
+	SEARCH_ADDRESS     = "address"
+	SEARCH_TOKEN       = "token"
+	SEARCH_CONTRACT    = "contract" // a labelled contract: build artifact or registered abi
+	SEARCH_ENS         = "ens"
+	SEARCH_MIN_NAME    = 2  // shortest text matched against token and contract names
+	SEARCH_MAX_MATCHES = 50 // matches listed at most
+)
+
+// the ENS registry, at the same address on every chain it is deployed to
+const ENS_REGISTRY = "0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e"
+
+var (
+	ensNamePattern      = regexp.MustCompile(`^([a-z0-9-]+\.)+[a-z0-9-]+$`)
+	ensResolverSelector = crypto.Keccak256([]byte("resolver(bytes32)"))[:4]
+	ensAddrSelector     = crypto.Keccak256([]byte("addr(bytes32)"))[:4]
+)
+
+// searchMatch is one thing the searched text names
+type searchMatch struct {
+	Kind   string `json:"kind"`
+	Title  string `json:"title"`
+	Detail string `json:"detail,omitempty"`
+	Link   string `json:"link"` // its page, on the network searched
+	exact  bool   // a name equal to the text, not only containing it
+}
+
+// searchPage is the content of /search
+type searchPage struct {
+	Query   string        `json:"query"`
+	Matches []searchMatch `json:"matches"`
+}
+
+/*
+search function: classifies query and returns everything on the network of ctx
+it may name: a block by number or hash, a transaction, an address, an ENS name,
+or tokens and labelled contracts by name. Names equal to the query hide those
+only containing it
+*/
+func search(ctx context.Context, query string) (searchPage, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return searchPage{}, invalidInput("Enter a block number, block or transaction hash, address or name to search for")
+	}
+	data := searchPage{Query: query, Matches: []searchMatch{}}
+	base := networkBase(ctx)
+	hex := query
+	if !strings.HasPrefix(hex, "0x") && !strings.HasPrefix(hex, "0X") {
+		hex = "0x" + hex
+	}
+
+	switch {
+	case isBlockNumber(query):
+		number, err := strconv.ParseUint(strings.TrimPrefix(query, "#"), 10, 64)
+		if err != nil {
+			return data, invalidInput("Block number " + query + " is out of range")
+		}
+		head, err := nodeOf(ctx).BlockNumber(ctx)
+		if err != nil {
+			return data, nodeError("Reason: `eth_blockNumber` failed. Couldn't able to search the blocks", err)
+		}
+		if number <= head {
+			n := strconv.FormatUint(number, 10)
+			data.Matches = append(data.Matches, searchMatch{Kind: SEARCH_BLOCK, Title: "Block " + n, Link: base + "/blockdetails?block=" + n})
+		}
+
+	case hashPattern.MatchString(hex):
+		hash := common.HexToHash(hex)
+		block, err := fetchBlockByHash(ctx, hash)
+		switch {
+		case err == nil:
+			data.Matches = append(data.Matches, searchMatch{Kind: SEARCH_BLOCK, Title: "Block " + block.Number().String(), Detail: hash.Hex(), Link: base + "/blockdetails?block=" + hash.Hex()})
+		case !errors.Is(err, ethereum.NotFound):
+			return data, nodeError("Couldn't able to look the hash up as a block", err)
+		}
+		tx, err := fetchTransaction(ctx, hash)
+		switch {
+		case err == nil:
+			data.Matches = append(data.Matches, searchMatch{Kind: SEARCH_TRANSACTION, Title: "Transaction " + tx.Hash().Hex(), Link: base + "/txinfo?txhash=" + tx.Hash().Hex()})
+		case !errors.Is(err, ethereum.NotFound):
+			return data, nodeError("Couldn't able to look the hash up as a transaction", err)
+		}
+
+	case common.IsHexAddress(hex) && len(hex) == 2+2*common.AddressLength:
+		match, err := addressMatch(ctx, common.HexToAddress(hex))
+		if err != nil {
+			return data, err
+		}
+		data.Matches = append(data.Matches, match)
+
+	default:
+		if name := strings.ToLower(query); ensNamePattern.MatchString(name) {
+			address, ok, err := resolveENS(ctx, name)
+			if err != nil {
+				return data, nodeError("Couldn't able to resolve the ENS name "+name, err)
+			}
+			if ok {
+				data.Matches = append(data.Matches, searchMatch{Kind: SEARCH_ENS, Title: name, Detail: address.Hex(), Link: base + "/address/" + address.Hex(), exact: true})
+			}
+		}
+		if len(query) >= SEARCH_MIN_NAME {
+			named, err := nameMatches(ctx, query)
+			if err != nil {
+				return data, err
+			}
+			data.Matches = append(data.Matches, named...)
+		}
+	}
+
+	// a name equal to the query wins over names containing it
+	exact := data.Matches[:0:0]
+	for _, match := range data.Matches {
+		if match.exact {
+			exact = append(exact, match)
+		}
+	}
+	if len(exact) > 0 && len(exact) < len(data.Matches) {
+		data.Matches = exact
+	}
+	if len(data.Matches) > SEARCH_MAX_MATCHES {
+		data.Matches = data.Matches[:SEARCH_MAX_MATCHES]
+	}
+	return data, nil
+}
+
+// isBlockNumber reports whether query is a decimal block number, optionally written #123
+func isBlockNumber(query string) bool {
+	digits := strings.TrimPrefix(query, "#")
+	if digits == "" {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+addressMatch function: the match of an address, told apart as a contract or an
+account by its code
+*/
+func addressMatch(ctx context.Context, address common.Address) (searchMatch, error) {
+	match := searchMatch{Kind: SEARCH_ADDRESS, Title: address.Hex(), Link: networkBase(ctx) + "/address/" + address.Hex()}
+	code, err := nodeOf(ctx).CodeAt(ctx, address, nil)
+	if err != nil {
+		return match, nodeError("Reason: `eth_getCode` failed for "+address.Hex(), err)
+	}
+	match.Detail = "account"
+	if len(code) > 0 {
+		match.Detail = "contract"
+		if name := addressLabel(ctx, address); name != "" {
+			match.Detail = "contract " + name
+		}
+	}
+	return match, nil
+}
+
+/*
+nameMatches function: the tokens whose name or symbol and the labelled
+contracts whose name contain query, ignoring case; a contract that is a token
+is listed once, as the token
+*/
+func nameMatches(ctx context.Context, query string) ([]searchMatch, error) {
+	needle := strings.ToLower(query)
+	base := networkBase(ctx)
+	seen := make(map[common.Address]bool)
+	var matches []searchMatch
+
+	known, err := knownTokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range known {
+		name, symbol := strings.ToLower(token.Name), strings.ToLower(token.Symbol)
+		if !strings.Contains(name, needle) && !strings.Contains(symbol, needle) {
+			continue
+		}
+		seen[token.Contract] = true
+		title := token.Name
+		if token.Symbol != "" {
+			title += " (" + token.Symbol + ")"
+		}
+		matches = append(matches, searchMatch{
+			Kind:   SEARCH_TOKEN,
+			Title:  title,
+			Detail: token.Standard + " " + token.Contract.Hex(),
+			Link:   base + "/address/" + token.Contract.Hex(),
+			exact:  name == needle || symbol == needle,
+		})
+	}
+
+	for address, label := range addressLabels(ctx) {
+		contract := common.HexToAddress(address)
+		if seen[contract] || !strings.Contains(strings.ToLower(label), needle) {
+			continue
+		}
+		matches = append(matches, searchMatch{
+			Kind:   SEARCH_CONTRACT,
+			Title:  label,
+			Detail: contract.Hex(),
+			Link:   base + "/address/" + contract.Hex(),
+			exact:  strings.ToLower(label) == needle,
+		})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].exact != matches[j].exact {
+			return matches[i].exact
+		}
+		if matches[i].Title != matches[j].Title {
+			return matches[i].Title < matches[j].Title
+		}
+		return matches[i].Link < matches[j].Link
+	})
+	return matches, nil
+}
+
+/*
+knownTokens function: the tokens whose metadata was resolved on the network of
+ctx, from the index when it has one and otherwise from the memory cache
+*/
+func knownTokens(ctx context.Context) ([]tokenInfo, error) {
+	if idx := activeIndex(ctx); idx != nil {
+		known, err := idx.tokens()
+		if err != nil {
+			return nil, &explorerError{Kind: ErrInternal, Message: "Couldn't able to read the tokens from the index", Err: err}
+		}
+		return known, nil
+	}
+	return tokens.list(networkOf(ctx).URL), nil
+}
+
+// *********************** ENS *************************************************
+
+// ensNamehash is the ENS node of a normalized name
+func ensNamehash(name string) common.Hash {
+	var node common.Hash
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		node = crypto.Keccak256Hash(node.Bytes(), crypto.Keccak256([]byte(labels[i])))
+	}
+	return node
+}
+
+/*
+resolveENS function: the address name resolves to through the ENS registry;
+not ok when the chain has no registry or the name no resolver or address
+*/
+func resolveENS(ctx context.Context, name string) (common.Address, bool, error) {
+	node := ensNamehash(name)
+	resolver, err := callENS(ctx, common.HexToAddress(ENS_REGISTRY), ensResolverSelector, node)
+	if err != nil || resolver == (common.Address{}) {
+		return common.Address{}, false, err
+	}
+	address, err := callENS(ctx, resolver, ensAddrSelector, node)
+	if err != nil || address == (common.Address{}) {
+		return common.Address{}, false, err
+	}
+	return address, true, nil
+}
+
+// callENS calls a method of an ENS contract taking a node and returning an address
+func callENS(ctx context.Context, contract common.Address, selector []byte, node common.Hash) (common.Address, error) {
+	data := append(append([]byte{}, selector...), node.Bytes()...)
+	result, err := nodeOf(ctx).CallContract(ctx, ethereum.CallMsg{To: &contract, Data: data}, nil)
+	if err != nil {
+		if isContractFailure(err) {
+			return common.Address{}, nil
+		}
+		return common.Address{}, err
+	}
+	if len(result) < 32 {
+		return common.Address{}, nil // no contract there
+	}
+	return common.BytesToAddress(result[12:32]), nil
+}
+
+// *********************** token listing ***************************************
+
+func (reg *tokenRegistry) list(host string) []tokenInfo {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	list := make([]tokenInfo, 0, len(reg.tokens[host]))
+	for _, token := range reg.tokens[host] {
+		list = append(list, token)
+	}
+	return list
+}
+
+func (idx *blockIndex) tokens() ([]tokenInfo, error) {
+	var list []tokenInfo
+	err := idx.db.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(bucketTokens).ForEach(func(_, value []byte) error {
+			var token tokenInfo
+			if err := json.Unmarshal(value, &token); err != nil {
+				return err
+			}
+			list = append(list, token)
+			return nil
+		})
+	})
+	return list, err
+}
+
+// *********************** search page *****************************************
+
+/*
+searchHandler function: serves /search?q=, redirecting to the page of the
+only match and listing the matches to choose from otherwise
+*/
+func searchHandler(w http.ResponseWriter, r *http.Request) error {
+	data, err := search(r.Context(), r.URL.Query().Get("q"))
+	if err != nil {
+		return err
+	}
+	if len(data.Matches) == 1 {
+		http.Redirect(w, r, data.Matches[0].Link, http.StatusSeeOther)
+		return nil
+	}
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "search.html")
+	if err != nil {
+		return err
+	}
+	if len(data.Matches) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	return tmpl.Execute(w, data)
+}
+
+/*
+apiSearch function: GET /api/v1/search?q=, every match of the query
+*/
+func apiSearch(w http.ResponseWriter, r *http.Request) error {
+	data, err := search(r.Context(), r.URL.Query().Get("q"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestIsBlockNumber(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"0", true},
+		{"123", true},
+		{"#123", true},
+		{"18446744073709551616", true}, // out of range, reported by search
+		{"", false},
+		{"#", false},
+		{"##1", false},
+		{"-1", false},
+		{"1.5", false},
+		{"12a", false},
+		{"0x10", false},
+		{"１２", false}, // fullwidth digits
+	}
+	for _, tt := range tests {
+		if got := isBlockNumber(tt.query); got != tt.want {
+			t.Errorf("isBlockNumber(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestENSNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		isName   bool
+		namehash string // EIP-137 test vectors
+	}{
+		{name: "eth", isName: false, namehash: "0x93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae"},
+		{name: "foo.eth", isName: true, namehash: "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f"},
+		{name: "vitalik.eth", isName: true},
+		{name: "sub.name-1.eth", isName: true},
+		{name: "foo..eth", isName: false},
+		{name: ".eth", isName: false},
+		{name: "Foo.eth", isName: false}, // search lowers the query first
+		{name: "foo eth", isName: false},
+	}
+	for _, tt := range tests {
+		if got := ensNamePattern.MatchString(tt.name); got != tt.isName {
+			t.Errorf("ensNamePattern matches %q = %v, want %v", tt.name, got, tt.isName)
+		}
+		if tt.namehash != "" {
+			if got := ensNamehash(tt.name).Hex(); got != tt.namehash {
+				t.Errorf("ensNamehash(%q) = %s, want %s", tt.name, got, tt.namehash)
+			}
+		}
+	}
+}
+
+func TestSearchRejectsEmptyQuery(t *testing.T) {
+	for _, query := range []string{"", "   ", "\t\n"} {
+		_, err := search(context.Background(), query)
+		var e *explorerError
+		if !errors.As(err, &e) || e.Kind != ErrInvalidInput {
+			t.Errorf("search(%q) error = %v, want invalid input", query, err)
+		}
+	}
+}
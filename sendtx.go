@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// *********************** send transaction **************************************
+
+// FAUCET_AMOUNT is the ether the faucet sends when -faucet-amount is not set
+const FAUCET_AMOUNT = "1"
+
+// the faucet amount, set by the -faucet-amount flag; the funder is set per
+// network, see network.faucetFunder
+var faucetAmount = FAUCET_AMOUNT
+
+// abiInput is an argument input of the function picked on /send
+type abiInput struct {
+	Field string // form field of the value
+	Name  string
+	Type  string
+	Value string
+}
+
+// abiFunction is a function of the contract picked on /send
+type abiFunction struct {
+	Sig      string
+	ReadOnly bool // view or pure, called instead of sent
+	Payable  bool
+}
+
+// sendTxPage is the content of /send
+type sendTxPage struct {
+	Accounts     []string // unlocked and impersonated accounts of the node
+	From         string
+	To           string
+	Value        string // in ether
+	Gas          string
+	Data         string
+	Contract     string        // name of the contract at To when its abi is known
+	Functions    []abiFunction // of the contract at To
+	Function     *abiFunction
+	Inputs       []abiInput
+	TxHash       string       // of the sent transaction
+	CallResult   []decodedArg // of a read-only function
+	Message      string
+	Error        string
+	FaucetFunder string
+	FaucetAmount string
+}
+
+// sentTx answers the send and faucet api calls
+type sentTx struct {
+	TransactionHash string       `json:"transactionHash,omitempty"`
+	Link            string       `json:"link,omitempty"`
+	Result          []decodedArg `json:"result,omitempty"` // of a read-only function
+}
+
+/*
+parseABIValue function: converts a form value to the go value abi.Pack takes
+for typ; arrays and slices are JSON arrays, numbers decimal or 0x hex
+*/
+func parseABIValue(typ abi.Type, value string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	switch typ.T {
+	case abi.AddressTy:
+		if !common.IsHexAddress(value) {
+			return nil, fmt.Errorf("%q is not an address", value)
+		}
+		return common.HexToAddress(value), nil
+	case abi.BoolTy:
+		return strconv.ParseBool(value)
+	case abi.StringTy:
+		return value, nil
+	case abi.BytesTy:
+		return hexutil.Decode(value)
+	case abi.FixedBytesTy:
+		decoded, err := hexutil.Decode(value)
+		if err != nil || len(decoded) != typ.Size {
+			return nil, fmt.Errorf("%q is not %d bytes of 0x hex", value, typ.Size)
+		}
+		fixed := reflect.New(typ.GetType()).Elem()
+		reflect.Copy(fixed, reflect.ValueOf(decoded))
+		return fixed.Interface(), nil
+	case abi.IntTy, abi.UintTy:
+		number, ok := new(big.Int).SetString(value, 0)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		signed := typ.T == abi.IntTy
+		limit := new(big.Int).Lsh(big.NewInt(1), uint(typ.Size)) // 2^size, or 2^(size-1) when signed
+		if signed {
+			limit.Rsh(limit, 1)
+		}
+		if number.Cmp(limit) >= 0 || (signed && number.Cmp(new(big.Int).Neg(limit)) < 0) || (!signed && number.Sign() < 0) {
+			return nil, fmt.Errorf("%s does not fit in %s", value, typ)
+		}
+		goType := typ.GetType()
+		if goType == reflect.TypeOf(number) {
+			return number, nil
+		}
+		converted := reflect.New(goType).Elem()
+		if signed {
+			converted.SetInt(number.Int64())
+		} else {
+			converted.SetUint(number.Uint64())
+		}
+		return converted.Interface(), nil
+	case abi.SliceTy, abi.ArrayTy:
+		var elements []json.RawMessage
+		if err := json.Unmarshal([]byte(value), &elements); err != nil {
+			return nil, fmt.Errorf("%s takes a JSON array like [\"1\", \"2\"]", typ)
+		}
+		if typ.T == abi.ArrayTy && len(elements) != typ.Size {
+			return nil, fmt.Errorf("%s takes %d elements, not %d", typ, typ.Size, len(elements))
+		}
+		list := reflect.MakeSlice(reflect.SliceOf(typ.Elem.GetType()), len(elements), len(elements))
+		if typ.T == abi.ArrayTy {
+			list = reflect.New(typ.GetType()).Elem()
+		}
+		for i, element := range elements {
+			var text string
+			if err := json.Unmarshal(element, &text); err != nil {
+				text = string(element) // a bare number or boolean
+			}
+			converted, err := parseABIValue(*typ.Elem, text)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			list.Index(i).Set(reflect.ValueOf(converted))
+		}
+		return list.Interface(), nil
+	}
+	return nil, fmt.Errorf("%s arguments are not supported by the form, send the encoded data instead", typ)
+}
+
+/*
+functionBySig function: the function of a contract abi with the given signature,
+overloads have the same name
+*/
+func functionBySig(contract abi.ABI, sig string) (abi.Method, bool) {
+	for _, method := range contract.Methods {
+		if method.Sig == sig {
+			return method, true
+		}
+	}
+	return abi.Method{}, false
+}
+
+/*
+loadSendForm function: fills the send form from the request: the accounts that
+can send, and the functions of the contract at To with the inputs of the
+picked one when its abi is known
+*/
+func loadSendForm(ctx context.Context, r *http.Request) (sendTxPage, *contractABI, error) {
+	field := func(name string) string {
+		return strings.TrimSpace(r.FormValue(name))
+	}
+	data := sendTxPage{
+		From:         field("from"),
+		To:           field("to"),
+		Value:        field("value"),
+		Gas:          field("gas"),
+		Data:         field("data"),
+		FaucetFunder: networkOf(ctx).faucetFunder,
+		FaucetAmount: faucetAmount,
+	}
+	if err := networkOf(ctx).rpc.call(ctx, "eth_accounts", &data.Accounts); err != nil {
+		return data, nil, nodeError("Reason: `eth_accounts` failed. Couldn't able to list the node accounts", err)
+	}
+	devChain.mu.Lock()
+	for _, account := range devChain.impersonated[networkOf(ctx).URL] {
+		data.Accounts = append(data.Accounts, strings.ToLower(account.Hex()))
+	}
+	devChain.mu.Unlock()
+	if data.FaucetFunder == "" && len(data.Accounts) > 0 {
+		data.FaucetFunder = data.Accounts[0]
+	}
+
+	if !common.IsHexAddress(data.To) {
+		return data, nil, nil
+	}
+	entry := contractABIs.lookup(networkOf(ctx).URL, common.HexToAddress(data.To))
+	if entry == nil {
+		return data, nil, nil
+	}
+	data.Contract = entry.Name
+	if data.Contract == "" {
+		data.Contract = "contract with a registered ABI"
+	}
+	for _, method := range entry.ABI.Methods {
+		data.Functions = append(data.Functions, abiFunction{Sig: method.Sig, ReadOnly: method.IsConstant(), Payable: method.IsPayable()})
+	}
+	sort.Slice(data.Functions, func(i, j int) bool {
+		return data.Functions[i].Sig < data.Functions[j].Sig
+	})
+
+	if method, ok := functionBySig(entry.ABI, field("function")); ok {
+		data.Function = &abiFunction{Sig: method.Sig, ReadOnly: method.IsConstant(), Payable: method.IsPayable()}
+		for i, input := range method.Inputs {
+			name := fmt.Sprintf("arg%d", i)
+			data.Inputs = append(data.Inputs, abiInput{Field: name, Name: input.Name, Type: input.Type.String(), Value: r.FormValue(name)})
+		}
+	}
+	return data, entry, nil
+}
+
+/*
+submitTransaction function: sends the transaction of the filled form with
+eth_sendTransaction, or calls a read-only function with eth_call; the
+arguments of a picked function replace the data field
+*/
+func submitTransaction(ctx context.Context, data *sendTxPage, entry *contractABI) (sentTx, error) {
+	if !common.IsHexAddress(data.From) {
+		return sentTx{}, invalidInput("Pick the account to send from")
+	}
+	tx := map[string]interface{}{"from": common.HexToAddress(data.From)}
+	if data.To != "" {
+		if !common.IsHexAddress(data.To) {
+			return sentTx{}, invalidInput("Recipient must be a 0x-prefixed 20 byte hex address, or empty to deploy the data")
+		}
+		tx["to"] = common.HexToAddress(data.To)
+	}
+	if data.Value != "" {
+		wei, err := etherToWei(data.Value)
+		if err != nil {
+			return sentTx{}, err
+		}
+		tx["value"] = (*hexutil.Big)(wei)
+	}
+	if data.Gas != "" {
+		gas, err := strconv.ParseUint(data.Gas, 10, 64)
+		if err != nil {
+			return sentTx{}, invalidInput("Gas must be a number, or empty to let the node estimate it")
+		}
+		tx["gas"] = hexutil.Uint64(gas)
+	}
+
+	var method abi.Method
+	if data.Function != nil {
+		method, _ = functionBySig(entry.ABI, data.Function.Sig)
+		values := make([]interface{}, len(method.Inputs))
+		for i, input := range method.Inputs {
+			value, err := parseABIValue(input.Type, data.Inputs[i].Value)
+			if err != nil {
+				return sentTx{}, invalidInput(fmt.Sprintf("Argument %d (%s %s): %v", i, input.Type, input.Name, err))
+			}
+			values[i] = value
+		}
+		packed, err := method.Inputs.Pack(values...)
+		if err != nil {
+			return sentTx{}, invalidInput("Couldn't able to encode the arguments: " + err.Error())
+		}
+		tx["data"] = hexutil.Bytes(append(append([]byte{}, method.ID...), packed...))
+	} else if data.Data != "" {
+		calldata, err := hexutil.Decode(data.Data)
+		if err != nil {
+			return sentTx{}, invalidInput("Data must be 0x-prefixed hex")
+		}
+		tx["data"] = hexutil.Bytes(calldata)
+	}
+
+	node := networkOf(ctx).rpc
+	if data.Function != nil && data.Function.ReadOnly {
+		var output hexutil.Bytes
+		if err := node.call(ctx, "eth_call", &output, tx, "latest"); err != nil {
+			return sentTx{}, nodeError("Reason: `eth_call` of "+method.Sig+" failed", err)
+		}
+		values, err := method.Outputs.Unpack(output)
+		if err != nil {
+			return sentTx{}, &explorerError{Kind: ErrUpstream, Message: "Couldn't able to decode the result of " + method.Sig, Err: err}
+		}
+		return sentTx{Result: decodeArgs(method.Outputs, values)}, nil
+	}
+
+	var hash common.Hash
+	if err := node.call(ctx, "eth_sendTransaction", &hash, tx); err != nil {
+		return sentTx{}, nodeError("Reason: `eth_sendTransaction` failed. The sender must be unlocked or impersonated", err)
+	}
+	return sentTx{TransactionHash: hash.Hex(), Link: networkBase(ctx) + "/txinfo?txhash=" + hash.Hex()}, nil
+}
+
+/*
+fundFromFaucet function: sends the faucet amount from the faucet funder to
+address
+*/
+func fundFromFaucet(ctx context.Context, address string) (sentTx, error) {
+	if !common.IsHexAddress(address) {
+		return sentTx{}, invalidInput("Address must be a 0x-prefixed 20 byte hex string")
+	}
+	funder := networkOf(ctx).faucetFunder
+	if funder == "" {
+		var accounts []string
+		if err := networkOf(ctx).rpc.call(ctx, "eth_accounts", &accounts); err != nil {
+			return sentTx{}, nodeError("Reason: `eth_accounts` failed. Couldn't able to find the faucet funder", err)
+		}
+		if len(accounts) == 0 {
+			return sentTx{}, &explorerError{Kind: ErrUpstream, Message: "The node has no unlocked account to fund the faucet, set -faucet-funder"}
+		}
+		funder = accounts[0]
+	}
+	return submitTransaction(ctx, &sendTxPage{From: funder, To: address, Value: faucetAmount}, nil)
+}
+
+/*
+sendTxHandler function: serves /send, the form sending ether or calling a
+contract function from an unlocked account; picking a function reloads the
+form with its argument inputs
+*/
+func sendTxHandler(w http.ResponseWriter, r *http.Request) error {
+	data, entry, err := loadSendForm(r.Context(), r)
+	if err != nil {
+		return err
+	}
+	if r.Method == http.MethodPost {
+		sent, err := submitTransaction(r.Context(), &data, entry)
+		if err != nil {
+			data.Error = err.Error()
+		}
+		data.TxHash, data.CallResult = sent.TransactionHash, sent.Result
+	}
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "send.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+/*
+faucetHandler function: serves POST /faucet, tops up an address from the
+faucet funder and shows the transaction on the send page
+*/
+func faucetHandler(w http.ResponseWriter, r *http.Request) error {
+	data, _, err := loadSendForm(r.Context(), r)
+	if err != nil {
+		return err
+	}
+	address := strings.TrimSpace(r.FormValue("address"))
+	if sent, err := fundFromFaucet(r.Context(), address); err != nil {
+		data.Error = err.Error()
+	} else {
+		data.TxHash, data.Message = sent.TransactionHash, "Sent "+faucetAmount+" ETH to "+address
+	}
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "send.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+/*
+apiSend function: POST /api/v1/send, sends the transaction of the /send form
+fields, or calls a read-only function
+*/
+func apiSend(w http.ResponseWriter, r *http.Request) error {
+	data, entry, err := loadSendForm(r.Context(), r)
+	if err != nil {
+		return err
+	}
+	if function := strings.TrimSpace(r.FormValue("function")); function != "" && data.Function == nil {
+		return invalidInput("No function " + function + " in the ABI registered for " + data.To)
+	}
+	sent, err := submitTransaction(r.Context(), &data, entry)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, sent)
+}
+
+/*
+apiFaucet function: POST /api/v1/faucet with address, tops it up from the
+faucet funder
+*/
+func apiFaucet(w http.ResponseWriter, r *http.Request) error {
+	sent, err := fundFromFaucet(r.Context(), strings.TrimSpace(r.FormValue("address")))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, sent)
+}
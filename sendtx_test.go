@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+func TestParseABIValue(t *testing.T) {
+	const (
+		maxUint256 = "115792089237316195423570985008687907853269984665640564039457584007913129639935" // 2^256-1
+		twoTo256   = "115792089237316195423570985008687907853269984665640564039457584007913129639936"
+		maxInt256  = "57896044618658097711785492504343953926634992332820282019728792003956564819967" // 2^255-1
+		minInt256  = "-57896044618658097711785492504343953926634992332820282019728792003956564819968"
+	)
+	tests := []struct {
+		typ   string
+		value string
+		want  string // printed with %v, empty when value is rejected
+	}{
+		{"int8", "-128", "-128"},
+		{"int8", "127", "127"},
+		{"int8", "-129", ""},
+		{"int8", "128", ""},
+		{"int8", "0x7f", "127"},
+		{"uint8", "255", "255"},
+		{"uint8", "256", ""},
+		{"uint8", "-1", ""},
+		{"uint64", "18446744073709551615", "18446744073709551615"},
+		{"uint64", "18446744073709551616", ""},
+		{"uint256", maxUint256, maxUint256},
+		{"uint256", twoTo256, ""},
+		{"uint256", "-1", ""},
+		{"int256", maxInt256, maxInt256},
+		{"int256", minInt256, minInt256},
+		{"int256", maxUint256, ""},
+		{"uint256", "1.5", ""},
+		{"uint256", "", ""},
+		{"bytes4", "0x01020304", "[1 2 3 4]"},
+		{"bytes4", "0x010203", ""},
+		{"bytes4", "0x0102030405", ""},
+		{"bytes4", "01020304", ""},
+		{"bytes", "0x", "[]"},
+		{"bytes", "0xff", "[255]"},
+		{"bool", "true", "true"},
+		{"bool", "yes", ""},
+		{"string", "  hello ", "hello"},
+		{"address", "0x00000000000000000000000000000000000000a1", "0x00000000000000000000000000000000000000A1"},
+		{"address", "0xa1", ""},
+		{"uint256[2]", `["1", 2]`, "[1 2]"},
+		{"uint256[2]", `["1"]`, ""},
+		{"uint256[2]", `["1", "2", "3"]`, ""},
+		{"uint8[2]", `["1", "256"]`, ""},
+		{"uint256[]", `[]`, "[]"},
+		{"uint256[]", `["0x10", "3"]`, "[16 3]"},
+		{"uint256[]", `1`, ""},
+		{"uint256[2][]", `[["1", "2"], ["3", "4"]]`, "[[1 2] [3 4]]"},
+		{"uint256[2][]", `[["1", "2"], ["3"]]`, ""},
+		{"bool[][]", `[[true], [false, true]]`, "[[true] [false true]]"},
+	}
+	for _, tt := range tests {
+		typ, err := abi.NewType(tt.typ, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseABIValue(typ, tt.value)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseABIValue(%s, %q) = %v, want an error", tt.typ, tt.value, got)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprintf("%v", got) != tt.want {
+			t.Errorf("parseABIValue(%s, %q) = %v, %v; want %s", tt.typ, tt.value, got, err, tt.want)
+			continue
+		}
+		// the value must also be one abi.Pack takes for the type
+		if _, err := (abi.Arguments{{Type: typ}}).Pack(got); err != nil {
+			t.Errorf("parseABIValue(%s, %q) = %v, which abi.Pack rejects: %v", tt.typ, tt.value, got, err)
+		}
+	}
+}
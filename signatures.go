@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/mux"
+)
+
+// *********************** signature database **********************************
+
+// how a call or log was decoded
+const (
+	DECODED_FROM_ABI        = "abi"
+	DECODED_FROM_SIGNATURES = "signature database"
+)
+
+//go:embed signatures/bundled.txt
+var bundledSignatures string
+
+// signatureDB maps function selectors and event topics back to signatures
+type signatureDB struct {
+	mu        sync.RWMutex
+	functions map[[4]byte][]string     // selector -> every signature hashing to it
+	events    map[common.Hash][]string // topic -> signature
+	known     map[string]bool          // signatures already added
+}
+
+var signatures = &signatureDB{
+	functions: make(map[[4]byte][]string),
+	events:    make(map[common.Hash][]string),
+	known:     make(map[string]bool),
+}
+
+/*
+splitTypes function: splits a comma separated type list at the top level,
+leaving tuple components together
+*/
+func splitTypes(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var types []string
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unbalanced parentheses")
+			}
+		case ',':
+			if depth == 0 {
+				types = append(types, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	return append(types, list[start:]), nil
+}
+
+/*
+typeMarshaling function: describes one canonical type, e.g. "uint256[]" or
+"(address,bytes)[]", the way the abi package expects it
+*/
+func typeMarshaling(name, typ string) (abi.ArgumentMarshaling, error) {
+	if !strings.HasPrefix(typ, "(") {
+		return abi.ArgumentMarshaling{Name: name, Type: typ}, nil
+	}
+	end := strings.LastIndexByte(typ, ')')
+	components, err := splitTypes(typ[1:end])
+	if err != nil {
+		return abi.ArgumentMarshaling{}, err
+	}
+	marshaling := abi.ArgumentMarshaling{Name: name, Type: "tuple" + typ[end+1:]}
+	for i, component := range components {
+		field, err := typeMarshaling(fmt.Sprintf("field%d", i), component)
+		if err != nil {
+			return abi.ArgumentMarshaling{}, err
+		}
+		marshaling.Components = append(marshaling.Components, field)
+	}
+	return marshaling, nil
+}
+
+/*
+parseSignature function: parses a canonical signature such as
+"transfer(address,uint256)" into its name and unnamed arguments
+*/
+func parseSignature(signature string) (string, abi.Arguments, error) {
+	open := strings.IndexByte(signature, '(')
+	if open <= 0 || !strings.HasSuffix(signature, ")") || strings.ContainsAny(signature, " \t") {
+		return "", nil, errors.New("not a canonical signature: " + signature)
+	}
+	types, err := splitTypes(signature[open+1 : len(signature)-1])
+	if err != nil {
+		return "", nil, err
+	}
+	args := make(abi.Arguments, len(types))
+	for i, typ := range types {
+		marshaling, err := typeMarshaling("", typ)
+		if err != nil {
+			return "", nil, err
+		}
+		args[i].Name = fmt.Sprintf("arg%d", i)
+		if args[i].Type, err = abi.NewType(marshaling.Type, "", marshaling.Components); err != nil {
+			return "", nil, err
+		}
+	}
+	return signature[:open], args, nil
+}
+
+/*
+add function: adds one signature under its function selector and event topic;
+the same text hashes to both, so it is looked up as either
+*/
+func (db *signatureDB) add(signature string) error {
+	if _, _, err := parseSignature(signature); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.known[signature] {
+		return nil
+	}
+	db.known[signature] = true
+	hash := crypto.Keccak256Hash([]byte(signature))
+	var selector [4]byte
+	copy(selector[:], hash[:4])
+	db.functions[selector] = append(db.functions[selector], signature)
+	db.events[hash] = append(db.events[hash], signature)
+	return nil
+}
+
+/*
+load function: reads signatures one per line; '#' starts a comment, a leading
+"function" or "event" keyword and a leading hex selector (as in 4byte
+exports) are ignored since the hashes are recomputed. Returns how many lines
+were added
+*/
+func (db *signatureDB) load(r io.Reader, origin string) (int, error) {
+	added := 0
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if comment := strings.IndexByte(text, '#'); comment >= 0 {
+			text = text[:comment]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "function" || fields[0] == "event" || (strings.HasPrefix(fields[0], "0x") && len(fields) > 1) {
+			fields = fields[1:]
+		}
+		if err := db.add(strings.Join(fields, "")); err != nil {
+			return added, fmt.Errorf("%s:%d: %w", origin, line, err)
+		}
+		added++
+	}
+	return added, scanner.Err()
+}
+
+/*
+importSignatures function: adds the signatures of a local file to the database
+*/
+func importSignatures(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return signatures.load(file, path)
+}
+
+func (db *signatureDB) functionCandidates(selector []byte) []string {
+	var key [4]byte
+	copy(key[:], selector)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return append([]string(nil), db.functions[key]...)
+}
+
+func (db *signatureDB) eventCandidates(topic common.Hash) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return append([]string(nil), db.events[topic]...)
+}
+
+// *********************** best-effort decoding ********************************
+
+/*
+unpackExactly function: unpacks data into args only when re-encoding the values
+gives data back, which rules out most wrong candidates
+*/
+func unpackExactly(args abi.Arguments, data []byte) ([]interface{}, bool) {
+	values, err := args.Unpack(data)
+	if err != nil {
+		return nil, false
+	}
+	packed, err := args.Pack(values...)
+	if err != nil || !bytes.Equal(packed, data) {
+		return nil, false
+	}
+	return values, true
+}
+
+/*
+decodeCall function: decodes calldata with the first candidate signature of
+its selector whose arguments fit; every candidate is reported since selectors
+collide
+*/
+func (db *signatureDB) decodeCall(data []byte) *decodedCall {
+	if len(data) < 4 {
+		return nil
+	}
+	candidates := db.functionCandidates(data[:4])
+	if len(candidates) == 0 {
+		return nil
+	}
+	call := &decodedCall{Source: DECODED_FROM_SIGNATURES, Candidates: candidates}
+	for _, signature := range candidates {
+		name, args, err := parseSignature(signature)
+		if err != nil {
+			continue
+		}
+		if values, ok := unpackExactly(args, data[4:]); ok {
+			call.Method, call.Signature, call.Args = name, signature, decodeArgs(args, values)
+			return call
+		}
+	}
+	// no candidate fits the arguments: name the likeliest, leave them undecoded
+	call.Signature = candidates[0]
+	call.Method = candidates[0][:strings.IndexByte(candidates[0], '(')]
+	return call
+}
+
+/*
+decodeLog function: decodes a log by its topic; the signature does not say
+which arguments are indexed, so the first len(topics)-1 are assumed to be, as
+is the usual convention
+*/
+func (db *signatureDB) decodeLog(entry *decodedLog, l *types.Log) {
+	if len(l.Topics) == 0 {
+		return
+	}
+	candidates := db.eventCandidates(l.Topics[0])
+	for _, signature := range candidates {
+		name, args, err := parseSignature(signature)
+		if err != nil || len(l.Topics)-1 > len(args) {
+			continue
+		}
+		for i := range args {
+			args[i].Indexed = i < len(l.Topics)-1
+		}
+		if _, ok := unpackExactly(args.NonIndexed(), l.Data); !ok {
+			continue
+		}
+		decoded, err := decodeEvent(abi.NewEvent(name, name, false, args), l)
+		if err != nil {
+			continue
+		}
+		entry.Event, entry.Signature, entry.Args = name, signature, decoded
+		entry.Source, entry.Candidates = DECODED_FROM_SIGNATURES, candidates
+		return
+	}
+}
+
+/*
+apiSignatures function: GET /api/v1/signatures/{hash}, the signatures known
+for a 4 byte function selector or a 32 byte event topic
+*/
+func apiSignatures(w http.ResponseWriter, r *http.Request) error {
+	hash, err := hexutil.Decode(mux.Vars(r)["hash"])
+	if err != nil || (len(hash) != 4 && len(hash) != common.HashLength) {
+		return invalidInput("hash must be a 0x-prefixed 4 byte selector or 32 byte event topic")
+	}
+	var candidates []string
+	if len(hash) == 4 {
+		candidates = signatures.functionCandidates(hash)
+	} else {
+		candidates = signatures.eventCandidates(common.BytesToHash(hash))
+	}
+	if len(candidates) == 0 {
+		return notFound("No signature is known for "+hexutil.Encode(hash), nil)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"hash": hexutil.Encode(hash), "signatures": candidates})
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	bolt "go.etcd.io/bbolt"
+)
+
+// *********************** token registry **************************************
+
+const (
+	STANDARD_ERC20   = "ERC-20"
+	STANDARD_ERC721  = "ERC-721"
+	STANDARD_ERC1155 = "ERC-1155"
+)
+
+// tokenInfo is the metadata of one token contract, resolved once and cached
+type tokenInfo struct {
+	Contract    common.Address `json:"contract"`
+	Standard    string         `json:"standard"`
+	Name        string         `json:"name"`
+	Symbol      string         `json:"symbol"`
+	Decimals    int64          `json:"decimals"`
+	TotalSupply *big.Int       `json:"totalSupply"`
+	Quirks      []string       `json:"quirks,omitempty"` // how the contract deviates from the standard
+}
+
+// tokenStats is a token of the /tokens listing with its holder and transfer counts
+type tokenStats struct {
+	tokenInfo
+	TotalSupplyFormatted *big.Float `json:"totalSupplyFormatted"`
+	Holders              int        `json:"holders"`
+	Transfers            int        `json:"transfers"`
+}
+
+// tokenMovement is the balance change a single transfer log makes
+type tokenMovement struct {
+	Standard string
+	Token    common.Address
+	From     common.Address
+	To       common.Address
+	Amount   *big.Int
+}
+
+// tokenRegistry caches resolved token metadata per node
+type tokenRegistry struct {
+	mu     sync.RWMutex
+	tokens map[string]map[common.Address]tokenInfo // node host -> contract -> metadata
+}
+
+var tokens = &tokenRegistry{tokens: make(map[string]map[common.Address]tokenInfo)}
+
+func (reg *tokenRegistry) get(host string, contract common.Address) (tokenInfo, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	token, ok := reg.tokens[host][contract]
+	return token, ok
+}
+
+func (reg *tokenRegistry) put(host string, token tokenInfo) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.tokens[host] == nil {
+		reg.tokens[host] = make(map[common.Address]tokenInfo)
+	}
+	reg.tokens[host][token.Contract] = token
+}
+
+func (reg *tokenRegistry) forget(host string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.tokens, host)
+}
+
+/*
+resolveToken function: returns the metadata of contract from the memory cache,
+the index or, on first sight, the node; the standard is only used when the
+contract is seen for the first time
+*/
+func resolveToken(ctx context.Context, contract common.Address, standard string) (tokenInfo, error) {
+	host := networkOf(ctx).URL
+	if token, ok := tokens.get(host, contract); ok {
+		return token, nil
+	}
+	idx := activeIndex(ctx)
+	if idx != nil {
+		if token, err := idx.token(contract); err == nil {
+			tokens.put(host, token)
+			return token, nil
+		}
+	}
+
+	token, err := queryToken(ctx, contract, standard)
+	if err != nil {
+		return tokenInfo{}, err
+	}
+	tokens.put(host, token)
+	if idx != nil {
+		idx.putToken(token)
+	}
+	return token, nil
+}
+
+/*
+isContractFailure function: reports whether err came from the contract (a
+revert or a missing method) rather than from reaching the node
+*/
+func isContractFailure(err error) bool {
+	var rpcErr rpc.Error
+	return errors.As(err, &rpcErr)
+}
+
+/*
+tokenText function: decodes a name or symbol result, falling back to the
+bytes32 encoding some early tokens use
+*/
+func tokenText(method string, result []byte) (text string, isBytes32 bool, ok bool) {
+	if values, err := erc20ABI.Unpack(method, result); err == nil && len(values) == 1 {
+		if text, ok := values[0].(string); ok {
+			return text, false, true
+		}
+	}
+	if len(result) == 32 {
+		return string(bytes.TrimRight(result, "\x00")), true, true
+	}
+	return "", false, false
+}
+
+/*
+queryToken function: asks the node for name, symbol, decimals and totalSupply,
+recording every non-standard answer as a quirk
+*/
+func queryToken(ctx context.Context, contract common.Address, standard string) (tokenInfo, error) {
+	token := tokenInfo{Contract: contract, Standard: standard}
+
+	for _, method := range []string{"name", "symbol"} {
+		result, err := callTokenRaw(ctx, contract, method)
+		if err != nil && !isContractFailure(err) {
+			return tokenInfo{}, err
+		}
+		text, isBytes32, ok := tokenText(method, result)
+		switch {
+		case err != nil || !ok:
+			token.Quirks = append(token.Quirks, "no "+method)
+		case isBytes32:
+			token.Quirks = append(token.Quirks, "bytes32 "+method)
+		}
+		if method == "name" {
+			token.Name = text
+		} else {
+			token.Symbol = text
+		}
+	}
+
+	var decimals uint8
+	ok, err := callTokenOptional(ctx, contract, "decimals", &decimals)
+	if err != nil {
+		return tokenInfo{}, err
+	}
+	if ok {
+		token.Decimals = int64(decimals)
+	} else if standard == STANDARD_ERC20 {
+		token.Quirks = append(token.Quirks, "no decimals")
+	}
+
+	supply := new(big.Int)
+	ok, err = callTokenOptional(ctx, contract, "totalSupply", &supply)
+	if err != nil {
+		return tokenInfo{}, err
+	}
+	if ok {
+		token.TotalSupply = supply
+	} else {
+		token.Quirks = append(token.Quirks, "no totalSupply")
+	}
+	return token, nil
+}
+
+/*
+callTokenOptional function: like callToken, but ok is false instead of an
+error when the contract does not implement the method
+*/
+func callTokenOptional(ctx context.Context, contract common.Address, method string, out interface{}) (ok bool, err error) {
+	result, err := callTokenRaw(ctx, contract, method)
+	if err != nil {
+		if isContractFailure(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return erc20ABI.UnpackIntoInterface(out, method, result) == nil, nil
+}
+
+// *********************** token statistics ************************************
+
+/*
+tokenMovementOf function: the balance change made by an ERC-20, ERC-721 or
+ERC-1155 transfer log; ok is false for any other log
+*/
+func tokenMovementOf(log *types.Log) (movement tokenMovement, ok bool) {
+	if IsTokenTransfer(log) {
+		return tokenMovement{
+			Standard: STANDARD_ERC20,
+			Token:    log.Address,
+			From:     common.BytesToAddress(log.Topics[1].Bytes()),
+			To:       common.BytesToAddress(log.Topics[2].Bytes()),
+			Amount:   new(big.Int).SetBytes(log.Data),
+		}, true
+	}
+	transfer, ok := nftTransferOf(log)
+	if !ok {
+		return tokenMovement{}, false
+	}
+	amount := new(big.Int)
+	for _, item := range transfer.Items {
+		amount.Add(amount, item.Amount)
+	}
+	return tokenMovement{
+		Standard: transfer.Standard,
+		Token:    log.Address,
+		From:     common.HexToAddress(transfer.From),
+		To:       common.HexToAddress(transfer.To),
+		Amount:   amount,
+	}, true
+}
+
+/*
+apply function: adds the movement to the running balances, scaled by sign (-1
+undoes it); mints and burns leave the zero address out
+*/
+func (m tokenMovement) apply(balances map[common.Address]*big.Int, sign int64) {
+	delta := new(big.Int).Mul(m.Amount, big.NewInt(sign))
+	if m.From != (common.Address{}) {
+		balances[m.From] = new(big.Int).Sub(balanceOr0(balances[m.From]), delta)
+	}
+	if m.To != (common.Address{}) {
+		balances[m.To] = new(big.Int).Add(balanceOr0(balances[m.To]), delta)
+	}
+}
+
+func balanceOr0(balance *big.Int) *big.Int {
+	if balance == nil {
+		return new(big.Int)
+	}
+	return balance
+}
+
+func countHolders(balances map[common.Address]*big.Int) int {
+	holders := 0
+	for _, balance := range balances {
+		if balance.Sign() > 0 {
+			holders++
+		}
+	}
+	return holders
+}
+
+/*
+tokenStatsFromNode function: replays every transfer log the node has to count
+holders and transfers per token; used when there is no index
+*/
+func tokenStatsFromNode(ctx context.Context) (map[common.Address]*tokenStats, map[common.Address]string, error) {
+	logs, err := nodeOf(ctx).FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: big.NewInt(0),
+		Topics:    [][]common.Hash{{erc20TransferTopic, erc1155TransferSingleTopic, erc1155TransferBatchTopic}},
+	})
+	if err != nil {
+		return nil, nil, nodeError("Reason: `@FilterLogs` failed. Couldn't able to list the token transfers", err)
+	}
+	stats := make(map[common.Address]*tokenStats)
+	standards := make(map[common.Address]string)
+	balances := make(map[common.Address]map[common.Address]*big.Int)
+	for i := range logs {
+		movement, ok := tokenMovementOf(&logs[i])
+		if !ok {
+			continue
+		}
+		if stats[movement.Token] == nil {
+			stats[movement.Token] = &tokenStats{}
+			standards[movement.Token] = movement.Standard
+			balances[movement.Token] = make(map[common.Address]*big.Int)
+		}
+		stats[movement.Token].Transfers++
+		movement.apply(balances[movement.Token], 1)
+	}
+	for token, holders := range balances {
+		stats[token].Holders = countHolders(holders)
+	}
+	return stats, standards, nil
+}
+
+/*
+loadTokenList function: every token contract seen on the chain with its
+metadata, holders and transfers, most transferred first
+*/
+func loadTokenList(ctx context.Context) ([]tokenStats, error) {
+	var stats map[common.Address]*tokenStats
+	var standards map[common.Address]string
+	var err error
+	if idx := activeIndex(ctx); idx != nil {
+		stats, standards, err = idx.tokenStats()
+		if err != nil {
+			return nil, &explorerError{Kind: ErrInternal, Message: "Couldn't able to read the tokens from the index", Err: err}
+		}
+	} else if stats, standards, err = tokenStatsFromNode(ctx); err != nil {
+		return nil, err
+	}
+
+	list := make([]tokenStats, 0, len(stats))
+	for contract, entry := range stats {
+		token, err := resolveToken(ctx, contract, standards[contract])
+		if err != nil {
+			return nil, nodeError("Reason: Couldn't able to resolve token "+contract.Hex(), err)
+		}
+		entry.tokenInfo = token
+		if token.TotalSupply != nil {
+			entry.TotalSupplyFormatted = ScaleTokenAmount(token.TotalSupply, token.Decimals)
+		}
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Transfers != list[j].Transfers {
+			return list[i].Transfers > list[j].Transfers
+		}
+		return bytes.Compare(list[i].Contract.Bytes(), list[j].Contract.Bytes()) < 0
+	})
+	return list, nil
+}
+
+/*
+tokensPage function: serves /tokens, the registry of every token on the chain
+*/
+func tokensPage(w http.ResponseWriter, r *http.Request) error {
+	data, err := loadTokenList(r.Context())
+	if err != nil {
+		return err
+	}
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "tokens.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+// *********************** token index *****************************************
+
+func (idx *blockIndex) token(contract common.Address) (tokenInfo, error) {
+	var token tokenInfo
+	err := idx.db.View(func(btx *bolt.Tx) error {
+		value := btx.Bucket(bucketTokens).Get(contract.Bytes())
+		if value == nil {
+			return errNotIndexed
+		}
+		return json.Unmarshal(value, &token)
+	})
+	return token, err
+}
+
+func (idx *blockIndex) putToken(token tokenInfo) error {
+	encoded, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return idx.db.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(bucketTokens).Put(token.Contract.Bytes(), encoded)
+	})
+}
+
+/*
+indexTokenMovement function: records one transfer in the token transfer and
+balance buckets; sign -1 undoes it during a rewind
+*/
+func indexTokenMovement(btx *bolt.Tx, l *types.Log, sign int64) error {
+	movement, ok := tokenMovementOf(l)
+	if !ok {
+		return nil
+	}
+	transferKey := logKey(movement.Token, l.BlockNumber, l.Index)
+	if sign > 0 {
+		value := append([]byte(movement.Standard+"|"), l.TxHash.Bytes()...)
+		if err := btx.Bucket(bucketTokenTransfers).Put(transferKey, value); err != nil {
+			return err
+		}
+	} else if err := btx.Bucket(bucketTokenTransfers).Delete(transferKey); err != nil {
+		return err
+	}
+
+	bucket := btx.Bucket(bucketTokenBalances)
+	balances := make(map[common.Address]*big.Int)
+	for _, holder := range []common.Address{movement.From, movement.To} {
+		if value := bucket.Get(pairKey(movement.Token, holder)); value != nil {
+			balances[holder], _ = new(big.Int).SetString(string(value), 10)
+		}
+	}
+	movement.apply(balances, sign)
+	for holder, balance := range balances {
+		// balances are kept signed: tokens minted without a Transfer make senders go negative
+		key := pairKey(movement.Token, holder)
+		if balance.Sign() == 0 {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		} else if err := bucket.Put(key, []byte(balance.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+tokenStats function: counts transfers and holders per token from the index
+*/
+func (idx *blockIndex) tokenStats() (map[common.Address]*tokenStats, map[common.Address]string, error) {
+	stats := make(map[common.Address]*tokenStats)
+	standards := make(map[common.Address]string)
+	err := idx.db.View(func(btx *bolt.Tx) error {
+		cursor := btx.Bucket(bucketTokenTransfers).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			token := common.BytesToAddress(key[:common.AddressLength])
+			if stats[token] == nil {
+				stats[token] = &tokenStats{}
+				standards[token] = string(bytes.SplitN(value, []byte("|"), 2)[0])
+			}
+			stats[token].Transfers++
+		}
+		cursor = btx.Bucket(bucketTokenBalances).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			entry := stats[common.BytesToAddress(key[:common.AddressLength])]
+			if entry != nil && len(value) > 0 && value[0] != '-' {
+				entry.Holders++
+			}
+		}
+		return nil
+	})
+	return stats, standards, err
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// *********************** call traces *****************************************
+
+// for one frame of a debug_traceTransaction callTracer result
+type callFrame struct {
+	Type    string          `json:"type"`
+	From    common.Address  `json:"from"`
+	To      common.Address  `json:"to"`
+	Value   *hexutil.Big    `json:"value,omitempty"`
+	Gas     *hexutil.Uint64 `json:"gas,omitempty"`
+	GasUsed *hexutil.Uint64 `json:"gasUsed,omitempty"`
+	Input   hexutil.Bytes   `json:"input,omitempty"`
+	Output  hexutil.Bytes   `json:"output,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Revert  string          `json:"revertReason,omitempty"` // set by newer geth versions
+	Calls   []callFrame     `json:"calls,omitempty"`
+}
+
+// for one frame of the call tree shown on the transaction page
+type callTraceFrame struct {
+	Type         string           `json:"type"` // CALL, DELEGATECALL, STATICCALL, CREATE, CREATE2, SELFDESTRUCT
+	From         string           `json:"from"`
+	To           string           `json:"to"`
+	Value        *big.Int         `json:"value"`
+	ValueInEth   *big.Float       `json:"valueInEth"`
+	Gas          uint64           `json:"gas"`
+	GasUsed      uint64           `json:"gasUsed"`
+	Input        string           `json:"input"`
+	Output       string           `json:"output"`
+	Decoded      *decodedCall     `json:"decoded,omitempty"`
+	Error        string           `json:"error,omitempty"`
+	RevertReason string           `json:"revertReason,omitempty"`
+	Depth        int              `json:"depth"`
+	Calls        []callTraceFrame `json:"calls,omitempty"`
+}
+
+// errTracerIgnored is returned by nodes that answer debug_traceTransaction with struct logs whatever the tracer
+var errTracerIgnored = errors.New("node ignored the callTracer")
+
+// for ETH moved by a contract inside a transaction
+type internalTransfer struct {
+	Type  string         `json:"type"` // CALL, CREATE, CREATE2, SELFDESTRUCT
+	From  common.Address `json:"from"`
+	To    common.Address `json:"to"`
+	Value *big.Int       `json:"value"`
+}
+
+/*
+traceCalls function: fetches the call tree of a transaction with the built-in
+callTracer
+*/
+func traceCalls(ctx context.Context, rpc *EthRPC, hash common.Hash) (*callFrame, error) {
+	var frame callFrame
+	err := rpc.call(ctx, "debug_traceTransaction", &frame, hash, map[string]string{"tracer": "callTracer"})
+	if err != nil {
+		return nil, err
+	}
+	if frame.Type == "" {
+		return nil, errTracerIgnored
+	}
+	return &frame, nil
+}
+
+/*
+isTracingUnsupported function: reports whether err means the node cannot trace
+at all, as opposed to failing on one transaction
+*/
+func isTracingUnsupported(err error) bool {
+	if errors.Is(err, errTracerIgnored) {
+		return true
+	}
+	if ethErr, ok := err.(EthError); ok {
+		if ethErr.Code == -32601 { // method not found
+			return true
+		}
+		message := strings.ToLower(ethErr.Message)
+		return strings.Contains(message, "not supported") || strings.Contains(message, "tracer")
+	}
+	return false
+}
+
+/*
+internalTransfers function: collects every non-zero value transfer below the
+top-level call, skipping frames that reverted
+*/
+func (f *callFrame) internalTransfers() []internalTransfer {
+	var transfers []internalTransfer
+	var walk func(frame *callFrame)
+	walk = func(frame *callFrame) {
+		for i := range frame.Calls {
+			child := &frame.Calls[i]
+			if child.Error != "" {
+				continue
+			}
+			if child.Value != nil && child.Value.ToInt().Sign() > 0 {
+				transfers = append(transfers, internalTransfer{
+					Type:  child.Type,
+					From:  child.From,
+					To:    child.To,
+					Value: new(big.Int).Set(child.Value.ToInt()),
+				})
+			}
+			walk(child)
+		}
+	}
+	if f.Error == "" {
+		walk(f)
+	}
+	return transfers
+}
+
+/*
+revertReason function: explains the output of a reverted frame of contract:
+an Error(string) message, a Panic(uint256) code or a custom error
+*/
+func revertReason(host string, contract common.Address, output []byte) string {
+	return decodeRevertData(host, &contract, output).Reason
+}
+
+/*
+callTree function: turns a callTracer frame into the view of the transaction
+page, decoding inputs and revert reasons along the way
+*/
+func (f *callFrame) callTree(host string, depth int) callTraceFrame {
+	frame := callTraceFrame{
+		Type:         f.Type,
+		From:         f.From.Hex(),
+		To:           f.To.Hex(),
+		Value:        new(big.Int),
+		Input:        hexutil.Encode(f.Input),
+		Output:       hexutil.Encode(f.Output),
+		Error:        f.Error,
+		RevertReason: f.Revert,
+		Depth:        depth,
+	}
+	if f.Value != nil {
+		frame.Value = f.Value.ToInt()
+	}
+	frame.ValueInEth = weiToEther(frame.Value)
+	if f.Gas != nil {
+		frame.Gas = uint64(*f.Gas)
+	}
+	if f.GasUsed != nil {
+		frame.GasUsed = uint64(*f.GasUsed)
+	}
+	if f.Type != "CREATE" && f.Type != "CREATE2" {
+		to := f.To
+		frame.Decoded = decodeCalldata(host, &to, f.Input)
+	}
+	if f.Error != "" && frame.RevertReason == "" {
+		frame.RevertReason = revertReason(host, f.To, f.Output)
+	}
+	for i := range f.Calls {
+		frame.Calls = append(frame.Calls, f.Calls[i].callTree(host, depth+1))
+	}
+	return frame
+}
+
+/*
+loadCallTrace function: traces tx on the current node for the transaction
+page; status explains why there is no tree instead of failing the page
+*/
+func loadCallTrace(ctx context.Context, tx *types.Transaction) (tree *callTraceFrame, status string) {
+	frame, err := traceCalls(ctx, networkOf(ctx).rpc, tx.Hash())
+	if err != nil {
+		if isTracingUnsupported(err) {
+			return nil, "The node does not support debug_traceTransaction with the callTracer, internal calls are not shown"
+		}
+		return nil, "Couldn't able to trace the transaction: " + err.Error()
+	}
+	root := frame.callTree(networkOf(ctx).URL, 0)
+	return &root, ""
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+)
+
+// *********************** node transport **************************************
+
+const (
+	NODE_CALL_TIMEOUT  = 10 * time.Second       // default limit of one node call
+	NODE_RETRIES       = 3                      // default retries of a transient failure
+	NODE_RETRY_BACKOFF = 250 * time.Millisecond // first retry delay, doubled every retry
+)
+
+// set from the command line
+var (
+	nodeCallTimeout = NODE_CALL_TIMEOUT
+	nodeRetries     = NODE_RETRIES
+	requestTimeout  = SERVER_TIMEOUT - REQUEST_MARGIN // limit of a whole page, below the server WriteTimeout
+)
+
+// json-rpc error codes nodes and providers answer when rate limiting
+var rateLimitCodes = map[int]bool{
+	-32005: true, // limit exceeded (EIP-1474, Infura)
+	-32029: true, // too many requests
+	429:    true, // some providers reuse the http status
+}
+
+// prefixes of the json-rpc methods that only read, safe to send again
+var readOnlyMethodPrefixes = []string{
+	"eth_get", "eth_call", "eth_blockNumber", "eth_chainId", "eth_gasPrice",
+	"eth_estimateGas", "eth_feeHistory", "eth_maxPriorityFeePerGas", "eth_accounts",
+	"eth_syncing", "eth_pendingTransactions", "net_", "web3_", "txpool_",
+	"debug_trace", "trace_",
+}
+
+// retriesExhausted reports the last failure of a call that was retried
+type retriesExhausted struct {
+	Attempts int
+	Err      error
+}
+
+func (e *retriesExhausted) Error() string {
+	return fmt.Sprintf("node still failing after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *retriesExhausted) Unwrap() error {
+	return e.Err
+}
+
+// retryFailure is err of a call given up after attempt retries, err itself when it was not retried
+func retryFailure(attempt int, err error) error {
+	if attempt == 0 {
+		return err
+	}
+	return &retriesExhausted{Attempts: attempt + 1, Err: err}
+}
+
+// retryTransport limits every node request to nodeCallTimeout and retries transient failures
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+// nodeHTTPClient is the http client of every node connection
+var nodeHTTPClient = &http.Client{Transport: &retryTransport{base: http.DefaultTransport}}
+
+/*
+RoundTrip function: sends req, retrying refused connections, 5xx and 429
+answers and json-rpc rate limit errors with exponential backoff, within the
+context of the request. Requests that may change state (eth_sendTransaction,
+evm_mine, ...) are only sent again when the connection was refused, any
+other failure may come after the node ran them
+*/
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := NODE_RETRY_BACKOFF
+	req, err := replayable(req)
+	if err != nil {
+		return nil, err
+	}
+	readOnly := isReadOnlyRequest(req)
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		resp, err := t.attempt(req)
+		if err == nil {
+			return resp, nil
+		}
+		var transient *transientError
+		if !errors.As(err, &transient) {
+			return nil, err
+		}
+		lastErr = transient.Err
+		if !shouldRetry(readOnly, transient.Err) || attempt >= nodeRetries || req.GetBody == nil {
+			return nil, retryFailure(attempt, lastErr)
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, retryFailure(attempt, lastErr)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+/*
+replayable function: a copy of req whose body can be read again, as the
+go-ethereum rpc client sends bodies that cannot
+*/
+func replayable(req *http.Request) (*http.Request, error) {
+	if req.GetBody != nil || req.Body == nil {
+		return req, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return req, nil
+}
+
+// shouldRetry reports whether a request failing with the transient err may be sent again
+func shouldRetry(readOnly bool, err error) bool {
+	return readOnly || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+/*
+isReadOnlyRequest function: reports whether every json-rpc call in the body of
+req only reads; unreadable bodies count as changing state
+*/
+func isReadOnlyRequest(req *http.Request) bool {
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return false
+	}
+	methods := requestMethods(data)
+	if len(methods) == 0 {
+		return false
+	}
+	for _, method := range methods {
+		if !isReadOnlyMethod(method) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestMethods returns the methods called by a json-rpc request or batch
+func requestMethods(body []byte) []string {
+	var items []struct {
+		Method string `json:"method"`
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] != '[' {
+		body = append(append([]byte{'['}, body...), ']')
+	}
+	if json.Unmarshal(body, &items) != nil {
+		return nil
+	}
+	methods := make([]string, len(items))
+	for i, item := range items {
+		methods[i] = item.Method
+	}
+	return methods
+}
+
+func isReadOnlyMethod(method string) bool {
+	for _, prefix := range readOnlyMethodPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// transientError marks a failure worth retrying
+type transientError struct {
+	Err error
+}
+
+func (e *transientError) Error() string {
+	return e.Err.Error()
+}
+
+/*
+attempt function: one try of req under nodeCallTimeout; the body is read here
+so the timeout covers it
+*/
+func (t *retryTransport) attempt(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), nodeCallTimeout)
+	defer cancel()
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+			return nil, &transientError{Err: err}
+		}
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return nil, &transientError{Err: fmt.Errorf("node answered %s: %s", resp.Status, strings.TrimSpace(string(body)))}
+	}
+	if code, ok := rateLimited(body); ok {
+		return nil, &transientError{Err: fmt.Errorf("node is rate limiting, error %d", code)}
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// rateLimited reports whether body is a single json-rpc error with a rate limit code
+func rateLimited(body []byte) (int, bool) {
+	if len(body) == 0 || body[0] != '{' {
+		return 0, false
+	}
+	var resp struct {
+		Error *EthError `json:"error"`
+	}
+	if json.Unmarshal(body, &resp) != nil || resp.Error == nil {
+		return 0, false
+	}
+	return resp.Error.Code, rateLimitCodes[resp.Error.Code]
+}
+
+// node connections by url with their number of users; ws and ipc connections are kept open and shared
+var (
+	nodeClientsMu  sync.Mutex
+	nodeClients    = make(map[string]*ethclient.Client)
+	nodeClientRefs = make(map[string]int)
+)
+
+/*
+dialNode function: connects an ethclient to url, reusing the connection of an
+earlier dial. http endpoints go through nodeHTTPClient for its timeouts and
+retries; ws://, wss:// and ipc endpoints (ipc:// or a socket path) redial by
+themselves when the connection drops. Every dial that succeeds is a use of
+the connection, given up again with releaseNode
+*/
+func dialNode(url string) (*ethclient.Client, error) {
+	nodeClientsMu.Lock()
+	defer nodeClientsMu.Unlock()
+	if client, ok := nodeClients[url]; ok {
+		nodeClientRefs[url]++
+		return client, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), nodeCallTimeout)
+	defer cancel()
+	var rpcClient *rpc.Client
+	var err error
+	switch {
+	case isWebsocketEndpoint(url):
+		rpcClient, err = rpc.DialWebsocket(ctx, url, "")
+	case isIPCEndpoint(url):
+		rpcClient, err = rpc.DialIPC(ctx, ipcPath(url))
+	default:
+		rpcClient, err = rpc.DialHTTPWithClient(url, nodeHTTPClient)
+	}
+	if err != nil {
+		return nil, err
+	}
+	nodeClients[url] = ethclient.NewClient(rpcClient)
+	nodeClientRefs[url] = 1
+	return nodeClients[url], nil
+}
+
+/*
+releaseNode function: gives up one use of the ethclient of url taken by
+dialNode; it is closed and forgotten once nobody else uses it
+*/
+func releaseNode(url string) {
+	nodeClientsMu.Lock()
+	defer nodeClientsMu.Unlock()
+	client, ok := nodeClients[url]
+	if !ok {
+		return
+	}
+	if nodeClientRefs[url]--; nodeClientRefs[url] > 0 {
+		return
+	}
+	client.Close()
+	delete(nodeClients, url)
+	delete(nodeClientRefs, url)
+}
+
+func isWebsocketEndpoint(url string) bool {
+	return strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://")
+}
+
+func isIPCEndpoint(url string) bool {
+	return strings.HasPrefix(url, "ipc://") || strings.HasPrefix(url, "/") || strings.HasSuffix(url, ".ipc")
+}
+
+func ipcPath(url string) string {
+	return strings.TrimPrefix(url, "ipc://")
+}
+
+/*
+withRequestTimeout function: gives every request a context that ends at
+requestTimeout, before the server gives up writing the response
+*/
+func withRequestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// *********************** websocket and ipc ***********************************
+
+// streamConn is one persistent connection carrying json-rpc messages both ways
+type streamConn interface {
+	writeMessage(msg []byte) error
+	readMessage() ([]byte, error)
+	Close() error
+}
+
+type wsConn struct {
+	*websocket.Conn
+	writeMu sync.Mutex
+}
+
+func (c *wsConn) writeMessage(msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (c *wsConn) readMessage() ([]byte, error) {
+	_, msg, err := c.ReadMessage()
+	return msg, err
+}
+
+// ipcConn reads one json value per message, the socket has no framing
+type ipcConn struct {
+	net.Conn
+	decoder *json.Decoder
+	writeMu sync.Mutex
+}
+
+func (c *ipcConn) writeMessage(msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.Write(msg)
+	return err
+}
+
+func (c *ipcConn) readMessage() ([]byte, error) {
+	var msg json.RawMessage
+	err := c.decoder.Decode(&msg)
+	return msg, err
+}
+
+// streamTransport sends the requests of every client of an endpoint over one connection
+type streamTransport struct {
+	dial    func(ctx context.Context) (streamConn, error)
+	refs    int // clients sharing the transport, guarded by streamsMu
+	mu      sync.Mutex
+	session *streamSession // nil until the first call and after the connection broke
+}
+
+// streamSession is one connection with the calls waiting for its responses
+type streamSession struct {
+	conn    streamConn
+	mu      sync.Mutex
+	pending map[uint64]*streamCall // request id -> call, every id of a batch points to it
+	err     error                  // why the connection broke
+}
+
+type streamCall struct {
+	ids  []uint64
+	done chan streamResult
+}
+
+type streamResult struct {
+	msg []byte
+	err error
+}
+
+var (
+	streamsMu sync.Mutex
+	streams   = make(map[string]*streamTransport)
+)
+
+/*
+streamTransportFor function: the shared transport of a ws, wss or ipc
+endpoint; nil for http endpoints. Every transport returned is a use of it,
+given up again with releaseStream
+*/
+func streamTransportFor(url string) *streamTransport {
+	var dial func(ctx context.Context) (streamConn, error)
+	switch {
+	case isWebsocketEndpoint(url):
+		dial = func(ctx context.Context) (streamConn, error) {
+			conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			return &wsConn{Conn: conn}, nil
+		}
+	case isIPCEndpoint(url):
+		dial = func(ctx context.Context) (streamConn, error) {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "unix", ipcPath(url))
+			if err != nil {
+				return nil, err
+			}
+			return &ipcConn{Conn: conn, decoder: json.NewDecoder(conn)}, nil
+		}
+	default:
+		return nil
+	}
+
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	if transport, ok := streams[url]; ok {
+		transport.refs++
+		return transport
+	}
+	streams[url] = &streamTransport{dial: dial, refs: 1}
+	return streams[url]
+}
+
+/*
+releaseStream function: gives up one use of the transport of url taken by
+streamTransportFor; it is closed and forgotten once nobody else uses it
+*/
+func releaseStream(url string) {
+	streamsMu.Lock()
+	transport, ok := streams[url]
+	if ok {
+		if transport.refs--; transport.refs > 0 {
+			ok = false
+		} else {
+			delete(streams, url)
+		}
+	}
+	streamsMu.Unlock()
+	if ok {
+		transport.close()
+	}
+}
+
+/*
+connect function: the open session, dialling a new connection with the same
+backoff as http retries when there is none
+*/
+func (t *streamTransport) connect(ctx context.Context) (*streamSession, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.session != nil {
+		return t.session, nil
+	}
+	backoff := NODE_RETRY_BACKOFF
+	for attempt := 0; ; attempt++ {
+		conn, err := t.dial(ctx)
+		if err == nil {
+			t.session = &streamSession{conn: conn, pending: make(map[uint64]*streamCall)}
+			go t.read(t.session)
+			return t.session, nil
+		}
+		if attempt >= nodeRetries {
+			return nil, retryFailure(attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, retryFailure(attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// close ends the open session of the transport, if any
+func (t *streamTransport) close() {
+	t.mu.Lock()
+	session := t.session
+	t.mu.Unlock()
+	if session != nil {
+		t.drop(session, errors.New("endpoint closed"))
+	}
+}
+
+/*
+read function: hands the responses of a session to the calls waiting for them
+until the connection breaks; then fails those calls and forgets the session so
+the next call reconnects
+*/
+func (t *streamTransport) read(session *streamSession) {
+	for {
+		msg, err := session.conn.readMessage()
+		if err != nil {
+			t.drop(session, err)
+			return
+		}
+		ids := messageIDs(msg)
+		if len(ids) == 0 {
+			continue // a subscription notification
+		}
+		session.mu.Lock()
+		call := session.pending[ids[0]]
+		if call != nil {
+			for _, id := range call.ids {
+				delete(session.pending, id)
+			}
+		}
+		session.mu.Unlock()
+		if call != nil {
+			call.done <- streamResult{msg: msg}
+		}
+	}
+}
+
+// drop closes a broken session and fails every call still waiting on it
+func (t *streamTransport) drop(session *streamSession, err error) {
+	t.mu.Lock()
+	if t.session == session {
+		t.session = nil
+	}
+	t.mu.Unlock()
+	session.conn.Close()
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.err == nil {
+		session.err = err
+	}
+	for id, call := range session.pending {
+		if call.ids[0] == id {
+			call.done <- streamResult{err: &transientError{Err: err}}
+		}
+		delete(session.pending, id)
+	}
+}
+
+/*
+roundTrip function: sends a request or batch and waits for its response for
+at most nodeCallTimeout; a request that could not be written is sent again on
+a new connection
+*/
+func (t *streamTransport) roundTrip(ctx context.Context, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, nodeCallTimeout)
+	defer cancel()
+	call := &streamCall{ids: messageIDs(body), done: make(chan streamResult, 1)}
+	if len(call.ids) == 0 {
+		return nil, errors.New("request has no id")
+	}
+
+	var session *streamSession
+	for attempt := 0; ; attempt++ {
+		var err error
+		if session, err = t.connect(ctx); err != nil {
+			return nil, err
+		}
+		session.mu.Lock()
+		err = session.err
+		if err == nil {
+			for _, id := range call.ids {
+				session.pending[id] = call
+			}
+		}
+		session.mu.Unlock()
+		if err == nil {
+			if err = session.conn.writeMessage(body); err == nil {
+				break
+			}
+			session.mu.Lock()
+			for _, id := range call.ids {
+				delete(session.pending, id)
+			}
+			session.mu.Unlock()
+			t.drop(session, err)
+		}
+		if attempt > 0 {
+			return nil, err
+		}
+	}
+
+	select {
+	case result := <-call.done:
+		return result.msg, result.err
+	case <-ctx.Done():
+		session.mu.Lock()
+		for _, id := range call.ids {
+			delete(session.pending, id)
+		}
+		session.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// messageIDs returns the ids of a json-rpc message or batch, none for notifications
+func messageIDs(msg []byte) []uint64 {
+	var items []struct {
+		ID *uint64 `json:"id"`
+	}
+	msg = bytes.TrimSpace(msg)
+	if len(msg) > 0 && msg[0] == '[' {
+		json.Unmarshal(msg, &items)
+	} else {
+		items = append(items, struct {
+			ID *uint64 `json:"id"`
+		}{})
+		json.Unmarshal(msg, &items[0])
+	}
+	var ids []uint64
+	for _, item := range items {
+		if item.ID != nil {
+			ids = append(ids, *item.ID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+func TestRequestMethods(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"single call", `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`, []string{"eth_blockNumber"}},
+		{"batch", `[{"id":1,"method":"eth_getBalance"},{"id":2,"method":"eth_sendTransaction"}]`, []string{"eth_getBalance", "eth_sendTransaction"}},
+		{"surrounding whitespace", "\n {\"method\":\"net_version\"} \n", []string{"net_version"}},
+		{"empty batch", `[]`, []string{}},
+		{"not json", `method=eth_call`, nil},
+		{"empty body", ``, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestMethods([]byte(tt.body)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requestMethods(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsReadOnlyRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"read", `{"method":"eth_getBlockByNumber"}`, true},
+		{"call", `{"method":"eth_call"}`, true},
+		{"trace", `{"method":"debug_traceTransaction"}`, true},
+		{"pool", `{"method":"txpool_content"}`, true},
+		{"send", `{"method":"eth_sendRawTransaction"}`, false},
+		{"mine", `{"method":"evm_mine"}`, false},
+		{"revert", `{"method":"evm_revert"}`, false},
+		{"unlock", `{"method":"personal_unlockAccount"}`, false},
+		{"batch of reads", `[{"method":"eth_getTransactionReceipt"},{"method":"eth_chainId"}]`, true},
+		{"batch with a write", `[{"method":"eth_getBalance"},{"method":"eth_sendTransaction"}]`, false},
+		{"empty batch", `[]`, false},
+		{"unreadable", `garbage`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://node", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := isReadOnlyRequest(req); got != tt.want {
+				t.Errorf("isReadOnlyRequest(%s) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	unavailable := errors.New("node answered 503 Service Unavailable")
+	tests := []struct {
+		name     string
+		readOnly bool
+		err      error
+		want     bool
+	}{
+		{"read, refused", true, refused, true},
+		{"read, reset", true, reset, true},
+		{"read, 5xx", true, unavailable, true},
+		{"write, refused", false, refused, true},
+		{"write, wrapped refused", false, fmt.Errorf("post: %w", refused), true},
+		{"write, reset", false, reset, false},
+		{"write, 5xx", false, unavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldRetry(tt.readOnly, tt.err); got != tt.want {
+				t.Errorf("shouldRetry(%v, %v) = %v, want %v", tt.readOnly, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryTransportAttempts(t *testing.T) {
+	defer func(retries int) { nodeRetries = retries }(nodeRetries)
+	nodeRetries = 2
+
+	tests := []struct {
+		name     string
+		body     string
+		attempts int32
+	}{
+		{"read is retried", `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`, 3},
+		{"write is sent once", `{"jsonrpc":"2.0","id":1,"method":"eth_sendTransaction","params":[]}`, 1},
+		{"batch with a write is sent once", `[{"id":1,"method":"eth_getBalance"},{"id":2,"method":"evm_mine"}]`, 1},
+	}
+	// only a retried call reports the retries as exhausted
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&attempts, 1)
+				if body, _ := ioutil.ReadAll(r.Body); string(body) != tt.body {
+					t.Errorf("attempt %d sent %q, want %q", n, body, tt.body)
+				}
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+			}))
+			defer node.Close()
+
+			// a body without GetBody, as the go-ethereum rpc client sends it
+			req, err := http.NewRequest(http.MethodPost, node.URL, ioutil.NopCloser(bytes.NewBufferString(tt.body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.GetBody = nil
+			_, err = (&retryTransport{base: http.DefaultTransport}).RoundTrip(req)
+			if err == nil {
+				t.Fatal("RoundTrip did not fail")
+			}
+			var exhausted *retriesExhausted
+			if retried := errors.As(err, &exhausted); retried != (tt.attempts > 1) {
+				t.Errorf("RoundTrip error = %v, reports exhausted retries %v after %d attempts", err, retried, tt.attempts)
+			}
+			if !strings.Contains(err.Error(), "503") {
+				t.Errorf("RoundTrip error = %v, want the 503 of the node", err)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.attempts {
+				t.Errorf("node got %d attempts, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestReleaseSharedConnections(t *testing.T) {
+	const httpURL, wsURL = "http://127.0.0.1:1/shared", "ws://127.0.0.1:1/shared"
+	kept := &network{Name: "kept", URL: httpURL}
+	if _, err := kept.connect(); err != nil {
+		t.Fatal(err)
+	}
+	failed := &network{Name: "failed", URL: httpURL}
+	if _, err := failed.connect(); err != nil {
+		t.Fatal(err)
+	}
+	failed.release()
+	nodeClientsMu.Lock()
+	client := nodeClients[httpURL]
+	nodeClientsMu.Unlock()
+	if client == nil || client != kept.client {
+		t.Error("releasing a network closed the client another network still uses")
+	}
+	kept.release()
+	nodeClientsMu.Lock()
+	_, cached := nodeClients[httpURL]
+	nodeClientsMu.Unlock()
+	if cached {
+		t.Error("client still cached after its last user released it")
+	}
+
+	keptStream := &network{Name: "kept", URL: wsURL, rpc: newClient(wsURL)}
+	failedStream := &network{Name: "failed", URL: wsURL, rpc: newClient(wsURL)}
+	failedStream.release()
+	if streamTransportFor(wsURL) != keptStream.rpc.stream {
+		t.Error("releasing a network dropped the transport another network still uses")
+	}
+	releaseStream(wsURL) // the lookup above
+	keptStream.release()
+	streamsMu.Lock()
+	_, cached = streams[wsURL]
+	streamsMu.Unlock()
+	if cached {
+		t.Error("transport still cached after its last user released it")
+	}
+}
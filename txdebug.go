@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+)
+
+// *********************** opcode debugger *************************************
+
+const (
+	TXDEBUG_MAX_STEPS      = 50000 // longest struct-log trace the debugger loads
+	TXDEBUG_CACHE_SIZE     = 8     // default of the traces kept in memory while stepping
+	TXDEBUG_LISTING_WINDOW = 12    // opcodes shown before and after the current one
+	TXDEBUG_SOURCE_WINDOW  = 8     // source lines shown around the highlighted ones
+)
+
+// for one step of a debug_traceTransaction struct-log trace
+type structLog struct {
+	PC      uint64            `json:"pc"`
+	Op      string            `json:"op"`
+	Gas     uint64            `json:"gas"`
+	GasCost uint64            `json:"gasCost"`
+	Depth   int               `json:"depth"`
+	Error   string            `json:"error,omitempty"`
+	Stack   []string          `json:"stack"`
+	Memory  []string          `json:"memory"`
+	Storage map[string]string `json:"storage"`
+}
+
+// for the default (struct logger) result of debug_traceTransaction
+type structLogTrace struct {
+	Gas         uint64      `json:"gas"`
+	Failed      bool        `json:"failed"`
+	ReturnValue string      `json:"returnValue"`
+	StructLogs  []structLog `json:"structLogs"`
+}
+
+// debugTrace is a struct-log trace with the code address executing each step
+type debugTrace struct {
+	hash      common.Hash
+	host      string
+	trace     structLogTrace
+	addresses []common.Address // code address per step, zero while running init code
+	failStep  int              // step that reverted or failed, -1 when none did
+	codes     map[common.Address][]byte
+	mu        sync.Mutex
+}
+
+// traceCache keeps the last few traces so stepping does not trace again
+type traceCache struct {
+	mu     sync.Mutex
+	order  []string
+	traces map[string]*debugTrace
+}
+
+var debugTraces = &traceCache{traces: make(map[string]*debugTrace)}
+
+// for one row of the opcode listing
+type debugOpcode struct {
+	Step    int    `json:"step"`
+	PC      uint64 `json:"pc"`
+	Op      string `json:"op"`
+	Depth   int    `json:"depth"`
+	Current bool   `json:"current"`
+}
+
+// for one word of memory or one storage slot
+type debugWord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// for one line of the source excerpt
+type debugSourceLine struct {
+	Number      int    `json:"number"`
+	Text        string `json:"text"`
+	Highlighted bool   `json:"highlighted"`
+}
+
+// for the txdebug page: one step with its state
+type txDebugPage struct {
+	TxHash      string            `json:"txHash"`
+	Failed      bool              `json:"failed"`
+	ReturnValue string            `json:"returnValue"`
+	TotalSteps  int               `json:"totalSteps"`
+	Step        int               `json:"step"`
+	PrevStep    int               `json:"prevStep"`
+	NextStep    int               `json:"nextStep"`
+	LastStep    int               `json:"lastStep"`
+	FailStep    int               `json:"failStep"` // -1 when nothing reverted
+	Address     string            `json:"address"`
+	Current     structLog         `json:"current"`
+	Listing     []debugOpcode     `json:"listing"`
+	Stack       []debugWord       `json:"stack"` // top first
+	Memory      []debugWord       `json:"memory"`
+	Storage     []debugWord       `json:"storage"`
+	SourceFile  string            `json:"sourceFile,omitempty"`
+	Source      []debugSourceLine `json:"source,omitempty"`
+	SourceError string            `json:"sourceError,omitempty"` // why no source is shown
+}
+
+/*
+get function: returns the cached trace of hash on the node of ctx, tracing it
+on a miss
+*/
+func (c *traceCache) get(ctx context.Context, hash common.Hash) (*debugTrace, error) {
+	key := networkOf(ctx).URL + "|" + hash.Hex()
+	c.mu.Lock()
+	cached := c.traces[key]
+	c.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	trace, err := loadDebugTrace(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.traces[key]; !ok {
+		c.order = append(c.order, key)
+		if len(c.order) > config.Caches.Traces {
+			delete(c.traces, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.traces[key] = trace
+	return trace, nil
+}
+
+// isCallOp reports whether op starts a frame running the code of its address argument
+func isCallOp(op string) bool {
+	return op == "CALL" || op == "CALLCODE" || op == "DELEGATECALL" || op == "STATICCALL"
+}
+
+/*
+loadDebugTrace function: fetches the struct-log trace of a transaction with
+memory and storage, and works out which code runs at every step
+*/
+func loadDebugTrace(ctx context.Context, hash common.Hash) (*debugTrace, error) {
+	tx, err := fetchTransaction(ctx, hash)
+	if err != nil {
+		return nil, nodeError("Txn with given hash is not available in the network", err)
+	}
+
+	var trace structLogTrace
+	traceConfig := map[string]interface{}{
+		"enableMemory":   true, // geth
+		"disableMemory":  false,
+		"disableStack":   false,
+		"disableStorage": false,
+		"limit":          TXDEBUG_MAX_STEPS + 1,
+	}
+	if err := networkOf(ctx).rpc.call(ctx, "debug_traceTransaction", &trace, hash, traceConfig); err != nil {
+		if isTracingUnsupported(err) {
+			return nil, &explorerError{Kind: ErrUpstream, Message: "The node does not support debug_traceTransaction", Err: err}
+		}
+		return nil, nodeError("Couldn't able to trace the transaction", err)
+	}
+	if len(trace.StructLogs) > TXDEBUG_MAX_STEPS {
+		return nil, invalidInput("The transaction runs more than " + strconv.Itoa(TXDEBUG_MAX_STEPS) + " steps, too many to step through")
+	}
+
+	debug := &debugTrace{hash: hash, host: networkOf(ctx).URL, trace: trace, failStep: -1, codes: make(map[common.Address][]byte)}
+
+	// follow the call frames: a call op followed by a deeper step entered its target
+	var frames []common.Address
+	if tx.To() != nil {
+		frames = append(frames, *tx.To())
+	} else {
+		frames = append(frames, common.Address{})
+	}
+	for i, step := range trace.StructLogs {
+		if step.Depth > 0 && step.Depth < len(frames) {
+			frames = frames[:step.Depth]
+		}
+		debug.addresses = append(debug.addresses, frames[len(frames)-1])
+		if i+1 < len(trace.StructLogs) && trace.StructLogs[i+1].Depth > step.Depth {
+			callee := common.Address{} // CREATE and CREATE2 run init code
+			if isCallOp(step.Op) && len(step.Stack) >= 2 {
+				callee = common.HexToAddress(stackWord(step.Stack[len(step.Stack)-2]))
+			}
+			frames = append(frames, callee)
+		}
+		if step.Op == "REVERT" || step.Op == "INVALID" || step.Error != "" {
+			debug.failStep = i
+		}
+	}
+	if !trace.Failed && debug.failStep >= 0 && trace.StructLogs[debug.failStep].Depth == 1 {
+		debug.failStep = -1
+	}
+	return debug, nil
+}
+
+// stackWord normalises a stack or memory word, which nodes print with or without 0x
+func stackWord(word string) string {
+	return "0x" + strings.TrimPrefix(word, "0x")
+}
+
+/*
+code function: the runtime code of address in the block of the transaction,
+fetched once per trace
+*/
+func (d *debugTrace) code(ctx context.Context, address common.Address) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if code, ok := d.codes[address]; ok {
+		return code, nil
+	}
+	receipt, err := fetchReceipt(ctx, d.hash)
+	if err != nil {
+		return nil, err
+	}
+	code, err := nodeOf(ctx).CodeAt(ctx, address, receipt.BlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	d.codes[address] = code
+	return code, nil
+}
+
+/*
+sourceFor function: the source excerpt of the contract at address around the
+instruction at pc, from the source map registered for it
+*/
+func (d *debugTrace) sourceFor(ctx context.Context, address common.Address, pc uint64) (file string, lines []debugSourceLine, reason string) {
+	if address == (common.Address{}) {
+		return "", nil, "Contract creation code has no deployed source map"
+	}
+	entry := contractABIs.lookup(networkOf(ctx).URL, address)
+	if entry == nil || entry.SourceInfo == nil {
+		return "", nil, "No source map is registered for " + address.Hex() + "; register its Truffle build artifact on /contracts"
+	}
+	code, err := d.code(ctx, address)
+	if err != nil {
+		return "", nil, "Couldn't able to fetch the code of " + address.Hex() + ": " + err.Error()
+	}
+	instruction, ok := instructionIndex(code, pc)
+	if !ok {
+		return "", nil, "pc is not at an instruction of the deployed code"
+	}
+	start, length, ok := entry.SourceInfo.locate(instruction)
+	if !ok {
+		return entry.SourceInfo.Path, nil, "The source map has no location for this instruction (compiler generated code)"
+	}
+	return entry.SourceInfo.Path, entry.SourceInfo.excerpt(start, length, TXDEBUG_SOURCE_WINDOW), ""
+}
+
+/*
+page function: builds the view of one step
+*/
+func (d *debugTrace) page(ctx context.Context, step int) txDebugPage {
+	logs := d.trace.StructLogs
+	data := txDebugPage{
+		TxHash:      d.hash.Hex(),
+		Failed:      d.trace.Failed,
+		ReturnValue: d.trace.ReturnValue,
+		TotalSteps:  len(logs),
+		Step:        step,
+		PrevStep:    step - 1,
+		NextStep:    step + 1,
+		LastStep:    len(logs) - 1,
+		FailStep:    d.failStep,
+	}
+	if len(logs) == 0 {
+		return data
+	}
+	if data.PrevStep < 0 {
+		data.PrevStep = 0
+	}
+	if data.NextStep > data.LastStep {
+		data.NextStep = data.LastStep
+	}
+
+	current := logs[step]
+	data.Current = current
+	data.Address = d.addresses[step].Hex()
+
+	for i := step - TXDEBUG_LISTING_WINDOW; i <= step+TXDEBUG_LISTING_WINDOW; i++ {
+		if i >= 0 && i < len(logs) {
+			data.Listing = append(data.Listing, debugOpcode{Step: i, PC: logs[i].PC, Op: logs[i].Op, Depth: logs[i].Depth, Current: i == step})
+		}
+	}
+	for i := len(current.Stack) - 1; i >= 0; i-- {
+		data.Stack = append(data.Stack, debugWord{Key: strconv.Itoa(len(current.Stack) - 1 - i), Value: stackWord(current.Stack[i])})
+	}
+	for i, word := range current.Memory {
+		data.Memory = append(data.Memory, debugWord{Key: "0x" + strconv.FormatInt(int64(i*32), 16), Value: stackWord(word)})
+	}
+	for slot, value := range current.Storage {
+		data.Storage = append(data.Storage, debugWord{Key: stackWord(slot), Value: stackWord(value)})
+	}
+	sort.Slice(data.Storage, func(i, j int) bool { return data.Storage[i].Key < data.Storage[j].Key })
+
+	data.SourceFile, data.Source, data.SourceError = d.sourceFor(ctx, d.addresses[step], current.PC)
+	return data
+}
+
+/*
+loadTxDebug function: loads the trace of the given transaction and the view of
+the requested step; "fail" jumps to the step that reverted
+*/
+func loadTxDebug(ctx context.Context, qss string, strStep string) (txDebugPage, error) {
+	if !hashPattern.MatchString(qss) {
+		return txDebugPage{}, invalidInput("Txn hash must be a 0x-prefixed 32 byte hex string")
+	}
+	trace, err := debugTraces.get(ctx, common.HexToHash(qss))
+	if err != nil {
+		return txDebugPage{}, err
+	}
+
+	step := 0
+	switch strStep {
+	case "":
+	case "fail":
+		if trace.failStep >= 0 {
+			step = trace.failStep
+		}
+	default:
+		step, err = strconv.Atoi(strStep)
+		if err != nil || step < 0 || step >= len(trace.trace.StructLogs) {
+			return txDebugPage{}, invalidInput("step must be between 0 and " + strconv.Itoa(len(trace.trace.StructLogs)-1))
+		}
+	}
+	return trace.page(ctx, step), nil
+}
+
+/*
+txDebugPageHandler function: serves /txdebug?tx=<hash>&step=N
+*/
+func txDebugPageHandler(w http.ResponseWriter, r *http.Request) error {
+	data, err := loadTxDebug(r.Context(), r.URL.Query().Get("tx"), r.URL.Query().Get("step"))
+	if err != nil {
+		return err
+	}
+
+	// render
+	tmpl, err := pageTemplate(r.Context(), "txdebug.html")
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+/*
+apiTxDebug function: GET /api/v1/tx/{hash}/debug?step=N, mirrors the debugger
+page
+*/
+func apiTxDebug(w http.ResponseWriter, r *http.Request) error {
+	data, err := loadTxDebug(r.Context(), mux.Vars(r)["hash"], r.URL.Query().Get("step"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, data)
+}
+
+// *********************** source maps *****************************************
+
+// sourceInfo is the deployed source map of a contract with the source it maps into
+type sourceInfo struct {
+	SourceMap string `json:"deployedSourceMap"`
+	Code      string `json:"source"`
+	Path      string `json:"sourcePath"`
+	FileIndex int    `json:"fileIndex"` // index of Code among the compilation's sources
+	entries   []sourceMapEntry
+	once      sync.Once
+}
+
+// one decompressed source map entry: s:l:f:j
+type sourceMapEntry struct {
+	Start, Length, File int
+}
+
+/*
+parseSourceInfo function: reads the deployed source map and source of a
+Truffle build artifact; nil when the document has none (Hardhat keeps them in
+build-info files)
+*/
+func parseSourceInfo(document []byte) *sourceInfo {
+	var artifact struct {
+		DeployedSourceMap string `json:"deployedSourceMap"`
+		Source            string `json:"source"`
+		SourcePath        string `json:"sourcePath"`
+		AST               struct {
+			Src string `json:"src"` // start:length:fileIndex of the whole source unit
+		} `json:"ast"`
+	}
+	if err := json.Unmarshal(document, &artifact); err != nil || artifact.DeployedSourceMap == "" || artifact.Source == "" {
+		return nil
+	}
+	info := &sourceInfo{SourceMap: artifact.DeployedSourceMap, Code: artifact.Source, Path: artifact.SourcePath}
+	if parts := strings.Split(artifact.AST.Src, ":"); len(parts) == 3 {
+		info.FileIndex, _ = strconv.Atoi(parts[2])
+	}
+	return info
+}
+
+/*
+decompress function: expands the compressed source map, where an empty field
+repeats the field of the previous entry
+*/
+func (s *sourceInfo) decompress() {
+	var previous sourceMapEntry
+	for _, item := range strings.Split(s.SourceMap, ";") {
+		entry := previous
+		fields := strings.Split(item, ":")
+		for i, target := range []*int{&entry.Start, &entry.Length, &entry.File} {
+			if i < len(fields) && fields[i] != "" {
+				*target, _ = strconv.Atoi(fields[i])
+			}
+		}
+		s.entries = append(s.entries, entry)
+		previous = entry
+	}
+}
+
+/*
+locate function: the source range of the given instruction; ok is false for
+instructions outside this contract's own source file
+*/
+func (s *sourceInfo) locate(instruction int) (start, length int, ok bool) {
+	s.once.Do(s.decompress)
+	if instruction >= len(s.entries) {
+		return 0, 0, false
+	}
+	entry := s.entries[instruction]
+	if entry.File != s.FileIndex || entry.Start < 0 || entry.Start+entry.Length > len(s.Code) {
+		return 0, 0, false
+	}
+	return entry.Start, entry.Length, true
+}
+
+/*
+excerpt function: the lines covering start..start+length with window lines of
+context on each side, the covered ones highlighted
+*/
+func (s *sourceInfo) excerpt(start, length, window int) []debugSourceLine {
+	first := strings.Count(s.Code[:start], "\n")
+	last := first + strings.Count(s.Code[start:start+length], "\n")
+	lines := strings.Split(s.Code, "\n")
+	var excerpt []debugSourceLine
+	for i := first - window; i <= last+window; i++ {
+		if i >= 0 && i < len(lines) {
+			excerpt = append(excerpt, debugSourceLine{Number: i + 1, Text: lines[i], Highlighted: i >= first && i <= last})
+		}
+	}
+	return excerpt
+}
+
+/*
+instructionIndex function: the index of the instruction at pc, counting
+PUSH data as part of its instruction as source maps do
+*/
+func instructionIndex(code []byte, pc uint64) (int, bool) {
+	index := 0
+	for offset := uint64(0); offset < uint64(len(code)); offset++ {
+		if offset == pc {
+			return index, true
+		}
+		if op := code[offset]; op >= 0x60 && op <= 0x7f { // PUSH1..PUSH32
+			offset += uint64(op - 0x5f)
+		}
+		index++
+	}
+	return 0, false
+}